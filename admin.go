@@ -0,0 +1,429 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logTail keeps the last lines written to the log.
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+func newLogTail(max int) *logTail {
+	return &logTail{max: max}
+}
+
+func (lt *logTail) Write(p []byte) (int, error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, line := range strings.Split(string(bytes.TrimRight(p, "\n")), "\n") {
+		lt.lines = append(lt.lines, line)
+	}
+	if n := len(lt.lines) - lt.max; n > 0 {
+		lt.lines = append([]string(nil), lt.lines[n:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the last lines written to the log, most recent last.
+func (lt *logTail) Lines() []string {
+	if lt == nil {
+		return nil
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return append([]string(nil), lt.lines...)
+}
+
+// timeLayouts are the layouts accepted by parseTime.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+}
+
+// parseTime parses an RFC 3339 time, or a time in one of timeLayouts in the
+// given location.
+func parseTime(v string, loc *time.Location) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		t, e := time.ParseInLocation(layout, v, loc)
+		if e == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+// adminStatus is the state of the server displayed by the admin console.
+type adminStatus struct {
+	Now           time.Time // time of the agenda displayed
+	Simulated     bool      // whether the simulation mode is enabled
+	Paused        bool      // whether the simulation is paused
+	Speed         float64   // speed factor of the simulation
+	Event         int
+	Source        string    // origin of the timetable
+	Loaded        time.Time // time at which the timetable was loaded
+	Clients       []clientInfo
+	Dropped       uint64 // messages replaced before being sent to slow clients
+	Evicted       uint64 // clients evicted for not taking their messages
+	Themes        []string
+	Announcements []adminAnnouncement
+	Delays        delays
+	Logs          []string
+}
+
+// adminAnnouncement is an announcement, along with its state.
+type adminAnnouncement struct {
+	Announcement
+	State string // scheduled, active or expired
+}
+
+// Age returns the age of the timetable.
+func (st adminStatus) Age() time.Duration {
+	return time.Since(st.Loaded)
+}
+
+// adminHandler serves the admin console.
+func (srv *server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	st := adminStatus{
+		Clients: srv.clients(),
+		Dropped: srv.dropped.Load(),
+		Evicted: srv.evicted.Load(),
+		Logs:    srv.logs.Lines(),
+	}
+
+	srv.tmu.RLock()
+	for name := range srv.themes {
+		st.Themes = append(st.Themes, name)
+	}
+	srv.tmu.RUnlock()
+	sort.Strings(st.Themes)
+
+	srv.mu.RLock()
+	st.Now = srv.agenda.Now
+	if clock, ok := srv.clock.(*simClock); ok {
+		st.Simulated = true
+		st.Paused = clock.paused
+		st.Speed = clock.speed
+	}
+	st.Event = srv.ttable.ID
+	st.Source = srv.source
+	st.Loaded = srv.loaded
+	now := srv.wall.Now()
+	for _, a := range srv.announces {
+		st.Announcements = append(st.Announcements, adminAnnouncement{a, a.State(now)})
+	}
+	st.Delays = newDelays()
+	for k, v := range srv.delays.Sessions {
+		st.Delays.Sessions[k] = v
+	}
+	for k, v := range srv.delays.Rooms {
+		st.Delays.Rooms[k] = v
+	}
+	srv.mu.RUnlock()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	err := srv.template(r).ExecuteTemplate(w, "admin", st)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// adminLogin wraps the handler of the admin console so browsers opening it
+// without credentials, when a token is configured, are asked for the token.
+// The console is then loaded with the token in a header.
+func (srv *server) adminLogin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.RLock()
+		token := srv.auth.token
+		srv.mu.RUnlock()
+		if token == "" || r.Header.Get("X-Admin-Token") != "" || r.Header.Get("Authorization") != "" {
+			h(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		err := srv.template(r).ExecuteTemplate(w, "admin-login", nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+const adminTmpl = `{{define "admin"}}<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta name="viewport" content="width=device-width, minimum-scale=1.0, initial-scale=1.0, user-scalable=yes">
+		<meta charset="utf-8">
+		<title>JI-2016 Admin</title>
+		<base href="{{base}}">
+		<style>
+			body {
+				font-family: 'Roboto', 'Helvetica Neue', Helvetica, Arial, sans-serif;
+				margin: 20px;
+				background: #eee;
+				color: #222;
+			}
+			section {
+				background: #fff;
+				padding: 10px 20px;
+				margin-bottom: 10px;
+				border-radius: 5px;
+			}
+			table {
+				border-collapse: collapse;
+			}
+			td form {
+				display: inline;
+			}
+			td, th {
+				padding: 2px 10px;
+				text-align: left;
+			}
+			pre.logs {
+				max-height: 300px;
+				overflow: auto;
+				background: #111;
+				color: #ddd;
+				padding: 10px;
+			}
+			#result {
+				font-weight: bold;
+			}
+		</style>
+		<script type="text/javascript">
+		var token = sessionStorage.getItem("admin-token");
+
+		// post submits the form to its admin endpoint and reloads the console.
+		function post(form) {
+			var req = new XMLHttpRequest();
+			req.open("POST", form.getAttribute("action"));
+			req.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+			if (token) {
+				req.setRequestHeader("X-Admin-Token", token);
+			}
+			req.onload = function() {
+				document.getElementById("result").textContent = req.status + ": " + req.responseText;
+				setTimeout(function() { window.location.reload(); }, 1500);
+			};
+			req.onerror = function() {
+				document.getElementById("result").textContent = "request failed";
+			};
+			req.send(new URLSearchParams(new FormData(form)).toString());
+			return false;
+		};
+		</script>
+	</head>
+
+	<body>
+		<h1>JI-2016 Admin</h1>
+		<p id="result"></p>
+
+		<section>
+			<h2>Status</h2>
+			<table>
+				<tr><th>Agenda time</th><td>{{if .Now.IsZero}}-{{else}}{{.Now.Format "2006-01-02 15:04:05 MST"}}{{end}}</td></tr>
+				<tr><th>Event</th><td>{{.Event}}</td></tr>
+				<tr><th>Timetable</th><td>{{.Source}}, loaded {{.Loaded.Format "2006-01-02 15:04:05"}} ({{fmtDuration .Age}} ago)</td></tr>
+			</table>
+			<form action="refresh-timetable" onsubmit="return post(this);">
+				<button type="submit">Refresh timetable</button>
+			</form>
+			<form action="refresh-time" onsubmit="return post(this);">
+				<input type="datetime-local" name="now" step="1">
+				<button type="submit">Set agenda time</button> (empty: current time)
+			</form>
+		</section>
+
+		{{- if .Simulated}}
+
+		<section>
+			<h2>Simulation ({{if .Paused}}paused{{else}}running{{end}}, x{{.Speed}})</h2>
+			<form action="simulation" onsubmit="return post(this);">
+				<input type="hidden" name="action" value="{{if .Paused}}resume{{else}}pause{{end}}">
+				<button type="submit">{{if .Paused}}Resume{{else}}Pause{{end}}</button>
+			</form>
+			<form action="simulation" onsubmit="return post(this);">
+				<input type="hidden" name="action" value="seek">
+				<input type="datetime-local" name="at" step="1" required>
+				<button type="submit">Seek</button>
+			</form>
+			<form action="simulation" onsubmit="return post(this);">
+				<input type="hidden" name="action" value="speed">
+				<input type="number" name="speed" min="0.1" step="any" value="{{.Speed}}" required>
+				<button type="submit">Set speed</button>
+			</form>
+		</section>
+		{{- end}}
+
+		<section>
+			<h2>Displays ({{len .Clients}})</h2>
+			<p>Dropped messages: {{.Dropped}}, evicted displays: {{.Evicted}}</p>
+			<table>
+				<tr><th>Name</th><th>Address</th><th>Transport</th><th>Language</th><th>User agent</th><th>Connected since</th><th>Last ack</th><th>Dropped</th><th></th></tr>
+				{{- $themes := .Themes}}
+				{{- range .Clients}}
+				<tr>
+					<td>{{.Name}}</td><td>{{.Addr}}</td><td>{{.Transport}}{{if .JSON}} (JSON){{end}}</td><td>{{.Lang}}</td><td>{{.Agent}}</td>
+					<td>{{.Since.Format "2006-01-02 15:04:05"}}</td><td>{{if .LastAck.IsZero}}-{{else}}{{.LastAck.Format "15:04:05"}}{{end}}</td><td>{{.Dropped}}</td>
+					<td>
+						{{- if .Name}}
+						<form action="display" onsubmit="return post(this);">
+							<input type="hidden" name="name" value="{{.Name}}">
+							<input type="hidden" name="action" value="reload">
+							<button type="submit">Reload</button>
+						</form>
+						<form action="display" onsubmit="return post(this);">
+							<input type="hidden" name="name" value="{{.Name}}">
+							<input type="hidden" name="action" value="theme">
+							<select name="theme">
+								{{- range $themes}}
+								<option value="{{.}}">{{.}}</option>
+								{{- end}}
+							</select>
+							<button type="submit">Theme</button>
+						</form>
+						<form action="display" onsubmit="return post(this);">
+							<input type="hidden" name="name" value="{{.Name}}">
+							<input type="hidden" name="action" value="target">
+							<input type="text" name="room" placeholder="room" size="10">
+							<input type="text" name="screen" placeholder="screen" size="10">
+							<button type="submit">Target</button>
+						</form>
+						{{- end}}
+					</td>
+				</tr>
+				{{- end}}
+			</table>
+		</section>
+
+		<section>
+			<h2>Announcements</h2>
+			<table>
+				<tr><th>ID</th><th>Priority</th><th>State</th><th>Message</th><th>Targets</th><th>Start</th><th>Expiry</th><th></th></tr>
+				{{- range .Announcements}}
+				<tr>
+					<td>{{.ID}}</td><td>{{.Priority}}</td><td>{{.State}}</td><td>{{.Message}}</td><td>{{.TargetList}}</td>
+					<td>{{if .Start.IsZero}}-{{else}}{{.Start.Format "2006-01-02 15:04:05"}}{{end}}</td>
+					<td>{{if .Expiry.IsZero}}-{{else}}{{.Expiry.Format "2006-01-02 15:04:05"}}{{end}}</td>
+					<td>
+						{{- if ne .State "expired"}}
+						<form action="announce" onsubmit="return post(this);">
+							<input type="hidden" name="cancel" value="{{.ID}}">
+							<button type="submit">Cancel</button>
+						</form>
+						{{- end}}
+					</td>
+				</tr>
+				{{- end}}
+			</table>
+			<form action="announce" onsubmit="return post(this);">
+				<input type="text" name="message" placeholder="message" size="40" required>
+				<select name="priority">
+					<option value="info">info</option>
+					<option value="warning">warning</option>
+					<option value="emergency">emergency</option>
+				</select>
+				<input type="text" name="ttl" placeholder="ttl (e.g. 30m)" size="10">
+				<input type="text" name="targets" placeholder="targets (comma-separated)" size="25">
+				<button type="submit">Announce</button>
+			</form>
+		</section>
+
+		<section>
+			<h2>Delays</h2>
+			<table>
+				{{- range $k, $v := .Delays.Sessions}}
+				<tr><td>session {{$k}}</td><td>{{fmtDuration $v}}</td></tr>
+				{{- end}}
+				{{- range $k, $v := .Delays.Rooms}}
+				<tr><td>room {{$k}}</td><td>{{fmtDuration $v}}</td></tr>
+				{{- end}}
+			</table>
+			<form action="delay" onsubmit="return post(this);">
+				<input type="text" name="session" placeholder="session ID" size="10">
+				<input type="text" name="room" placeholder="or room" size="15">
+				<input type="text" name="offset" placeholder="offset (e.g. +10m, 0 to clear)" size="25" required>
+				<button type="submit">Set delay</button>
+			</form>
+		</section>
+
+		<section>
+			<h2>Logs</h2>
+			<pre class="logs">{{range .Logs}}{{.}}
+{{end}}</pre>
+		</section>
+	</body>
+</html>
+{{end}}
+
+{{define "admin-login"}}<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta name="viewport" content="width=device-width, minimum-scale=1.0, initial-scale=1.0, user-scalable=yes">
+		<meta charset="utf-8">
+		<title>JI-2016 Admin</title>
+		<base href="{{base}}">
+		<script type="text/javascript">
+		// the token is kept for the session of the tab only, and sent in a
+		// header, so it never shows up in URLs.
+		function load() {
+			var token = sessionStorage.getItem("admin-token");
+			if (!token) {
+				return;
+			}
+			var req = new XMLHttpRequest();
+			req.open("GET", "admin");
+			req.setRequestHeader("X-Admin-Token", token);
+			req.onload = function() {
+				if (req.status != 200) {
+					sessionStorage.removeItem("admin-token");
+					document.getElementById("result").textContent = req.status + ": " + req.responseText;
+					return;
+				}
+				document.open();
+				document.write(req.responseText);
+				document.close();
+			};
+			req.onerror = function() {
+				document.getElementById("result").textContent = "request failed";
+			};
+			req.send();
+		};
+
+		function login(form) {
+			sessionStorage.setItem("admin-token", form.elements["token"].value);
+			load();
+			return false;
+		};
+
+		window.addEventListener("load", load);
+		</script>
+	</head>
+
+	<body>
+		<h1>JI-2016 Admin</h1>
+		<p id="result"></p>
+		<form onsubmit="return login(this);">
+			<input type="password" name="token" placeholder="admin token" autocomplete="current-password">
+			<button type="submit">Log in</button>
+		</form>
+	</body>
+</html>
+{{end}}
+`
@@ -0,0 +1,230 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priority is the priority level of an announcement.
+type Priority int
+
+const (
+	PriorityInfo      Priority = iota // regular banner
+	PriorityWarning                   // highlighted banner
+	PriorityEmergency                 // full-screen takeover
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInfo:
+		return "info"
+	case PriorityWarning:
+		return "warning"
+	case PriorityEmergency:
+		return "emergency"
+	}
+	return "Priority(" + strconv.Itoa(int(p)) + ")"
+}
+
+func parsePriority(s string) (Priority, error) {
+	switch s {
+	case "", "info":
+		return PriorityInfo, nil
+	case "warning":
+		return PriorityWarning, nil
+	case "emergency":
+		return PriorityEmergency, nil
+	}
+	return PriorityInfo, fmt.Errorf("invalid priority %q", s)
+}
+
+// Announcement is an ad-hoc message pushed by the organisers to the displays.
+type Announcement struct {
+	ID       int
+	Message  string
+	Priority Priority
+	Start    time.Time // zero means immediately
+	Expiry   time.Time // zero means until cancelled
+	Targets  []string  // screens or rooms the announcement is shown on. empty means all.
+}
+
+// Active returns whether the announcement is to be displayed at the given time.
+func (a Announcement) Active(now time.Time) bool {
+	if !a.Start.IsZero() && now.Before(a.Start) {
+		return false
+	}
+	if !a.Expiry.IsZero() && !now.Before(a.Expiry) {
+		return false
+	}
+	return true
+}
+
+// Expired returns whether the announcement is no longer displayed at the
+// given time, and never will be.
+func (a Announcement) Expired(now time.Time) bool {
+	return !a.Expiry.IsZero() && !now.Before(a.Expiry)
+}
+
+// State returns the state of the announcement at the given time: scheduled,
+// active or expired.
+func (a Announcement) State(now time.Time) string {
+	switch {
+	case a.Expired(now):
+		return "expired"
+	case !a.Active(now):
+		return "scheduled"
+	}
+	return "active"
+}
+
+func (a Announcement) CSSClass() string {
+	return "announcement-" + a.Priority.String()
+}
+
+// TargetList returns the '|'-separated list of targets of the announcement.
+func (a Announcement) TargetList() string {
+	return strings.Join(a.Targets, "|")
+}
+
+// activeAnnouncements returns the announcements to be displayed at the given time.
+// activeAnnouncements must be called with srv.mu held.
+func (srv *server) activeAnnouncements(now time.Time) []Announcement {
+	var o []Announcement
+	for _, a := range srv.announces {
+		if a.Active(now) {
+			o = append(o, a)
+		}
+	}
+	return o
+}
+
+// pruneAnnouncements removes the announcements expired at the given time.
+// pruneAnnouncements must be called with srv.mu held for writing.
+func (srv *server) pruneAnnouncements(now time.Time) {
+	o := srv.announces[:0]
+	for _, a := range srv.announces {
+		if !a.Expired(now) {
+			o = append(o, a)
+		}
+	}
+	for i := len(o); i < len(srv.announces); i++ {
+		srv.announces[i] = Announcement{}
+	}
+	srv.announces = o
+}
+
+// announceHandler creates a new announcement, or cancels the one whose ID is
+// given by the "cancel" form value.
+//
+// An announcement is described by the following form values:
+//   - message: the text of the announcement,
+//   - priority: info, warning or emergency,
+//   - start: RFC 3339 time at which the announcement is displayed (default: now),
+//   - expiry: RFC 3339 time at which the announcement is removed,
+//   - ttl: duration after which the announcement is removed (e.g. "30m"),
+//   - targets: comma-separated list of screens or rooms (default: all).
+func (srv *server) announceHandler(w http.ResponseWriter, r *http.Request) {
+	if v := r.FormValue("cancel"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid announcement id: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		srv.mu.Lock()
+		found := false
+		for i, a := range srv.announces {
+			if a.ID == id {
+				srv.announces = append(srv.announces[:i], srv.announces[i+1:]...)
+				found = true
+				break
+			}
+		}
+		srv.mu.Unlock()
+		if !found {
+			http.Error(w, fmt.Sprintf("no announcement with id=%d", id), http.StatusNotFound)
+			return
+		}
+		srv.kick()
+		slog.Info("announcement cancelled", "id", id)
+		fmt.Fprintf(w, "announcement-%d cancelled\n", id)
+		return
+	}
+
+	now := srv.wall.Now()
+	a, err := parseAnnouncement(r, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.mu.Lock()
+	srv.pruneAnnouncements(now)
+	srv.nextID++
+	a.ID = srv.nextID
+	srv.announces = append(srv.announces, a)
+	srv.mu.Unlock()
+	srv.kick()
+
+	slog.Info("announcement created", "id", a.ID, "priority", a.Priority, "message", a.Message)
+	fmt.Fprintf(w, "announcement-%d created\n", a.ID)
+}
+
+func parseAnnouncement(r *http.Request, now time.Time) (Announcement, error) {
+	var (
+		a   Announcement
+		err error
+	)
+
+	a.Message = strings.TrimSpace(r.FormValue("message"))
+	if a.Message == "" {
+		return a, fmt.Errorf("missing announcement message")
+	}
+
+	a.Priority, err = parsePriority(r.FormValue("priority"))
+	if err != nil {
+		return a, err
+	}
+
+	if v := r.FormValue("start"); v != "" {
+		a.Start, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return a, fmt.Errorf("invalid start time: %v", err)
+		}
+	}
+
+	switch {
+	case r.FormValue("expiry") != "":
+		a.Expiry, err = time.Parse(time.RFC3339, r.FormValue("expiry"))
+		if err != nil {
+			return a, fmt.Errorf("invalid expiry time: %v", err)
+		}
+	case r.FormValue("ttl") != "":
+		ttl, err := time.ParseDuration(r.FormValue("ttl"))
+		if err != nil {
+			return a, fmt.Errorf("invalid ttl: %v", err)
+		}
+		start := now
+		if !a.Start.IsZero() {
+			start = a.Start
+		}
+		a.Expiry = start.Add(ttl)
+	}
+
+	for _, v := range strings.Split(r.FormValue("targets"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		a.Targets = append(a.Targets, v)
+	}
+
+	return a, nil
+}
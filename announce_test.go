@@ -0,0 +1,100 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnouncementState(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name string
+		a    Announcement
+		want string
+	}{
+		{name: "immediate", a: Announcement{}, want: "active"},
+		{name: "started", a: Announcement{Start: now.Add(-time.Hour), Expiry: now.Add(time.Hour)}, want: "active"},
+		{name: "scheduled", a: Announcement{Start: now.Add(time.Hour)}, want: "scheduled"},
+		{name: "expired", a: Announcement{Expiry: now.Add(-time.Hour)}, want: "expired"},
+		{name: "expiring", a: Announcement{Expiry: now}, want: "expired"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.a.State(now); got != tc.want {
+				t.Fatalf("invalid state: got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPruneAnnouncements(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	srv := newServer(":0", testTimeTable(), fixedClock(at(9, 45)))
+	srv.wall = fixedClock(now)
+	srv.announces = []Announcement{
+		{ID: 1, Message: "past", Expiry: now.Add(-time.Hour)},
+		{ID: 2, Message: "current"},
+		{ID: 3, Message: "expiring", Expiry: now},
+		{ID: 4, Message: "future", Start: now.Add(time.Hour)},
+	}
+	srv.nextID = 4
+
+	// expired announcements are pruned when a new one is created.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/announce", strings.NewReader(url.Values{"message": {"new"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	srv.announceHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status: got=%d, want=%d (%s)", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var got []string
+	for _, a := range srv.announces {
+		got = append(got, a.Message)
+	}
+	if got, want := strings.Join(got, ","), "current,future,new"; got != want {
+		t.Fatalf("invalid announcements: got=%q, want=%q", got, want)
+	}
+
+	// and when the agenda is rendered.
+	srv.wall = fixedClock(now.Add(2 * time.Hour))
+	srv.announces[1].Expiry = now.Add(90 * time.Minute)
+	_, _, err := srv.render(srv.Now())
+	if err != nil {
+		t.Fatalf("could not render agenda: %+v", err)
+	}
+	if len(srv.announces) != 2 {
+		t.Fatalf("invalid number of announcements: got=%d, want=2", len(srv.announces))
+	}
+}
+
+func TestRenderAnnouncements(t *testing.T) {
+	srv := newServer(":0", testTimeTable(), fixedClock(at(9, 45)))
+	srv.wall = fixedClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	srv.announces = []Announcement{
+		{ID: 1, Message: "past", Expiry: time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{ID: 2, Message: "current", Start: time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{ID: 3, Message: "future", Start: time.Date(2020, 1, 1, 13, 0, 0, 0, time.UTC)},
+	}
+
+	// announcements are selected on the real time, whatever the time of
+	// the agenda.
+	_, _, err := srv.render(srv.Now())
+	if err != nil {
+		t.Fatalf("could not render agenda: %+v", err)
+	}
+	agenda := srv.agenda
+	if !agenda.Now.Equal(at(9, 45)) {
+		t.Fatalf("invalid agenda time: got=%v, want=%v", agenda.Now, at(9, 45))
+	}
+	if len(agenda.Announcements) != 1 || agenda.Announcements[0].Message != "current" {
+		t.Fatalf("invalid announcements: %+v", agenda.Announcements)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// apiVersion is the version of the JSON representation of the agenda.
+// It is bumped whenever a backward incompatible change is made.
+const apiVersion = 1
+
+// Websocket subprotocols understood by the /data endpoint.
+const (
+	protoHTML = "ji-agenda.v1+html" // pre-rendered HTML fragments (default)
+	protoJSON = "ji-agenda.v1+json" // JSON representation of the agenda
+)
+
+// apiAgenda is the JSON representation of an Agenda.
+// All durations are expressed in seconds.
+type apiAgenda struct {
+	Version       int               `json:"version"`
+	Now           time.Time         `json:"now"`
+	Sessions      []apiSession      `json:"sessions"`
+	Announcements []apiAnnouncement `json:"announcements"`
+}
+
+type apiSession struct {
+	Title         string            `json:"title"`
+	Room          string            `json:"room"`
+	Start         time.Time         `json:"start"`
+	Stop          time.Time         `json:"stop"`
+	Delay         int64             `json:"delay"`
+	Active        bool              `json:"active"`
+	Contributions []apiContribution `json:"contributions"`
+}
+
+type apiContribution struct {
+	Title      string         `json:"title"`
+	Start      time.Time      `json:"start"`
+	Stop       time.Time      `json:"stop"`
+	Duration   int64          `json:"duration"`
+	Elapsed    int64          `json:"elapsed"`
+	Remaining  int64          `json:"remaining"`
+	Progress   int            `json:"progress"`
+	Delay      int64          `json:"delay"`
+	Active     bool           `json:"active"`
+	Ending     bool           `json:"ending"`
+	Presenters []apiPresenter `json:"presenters"`
+}
+
+type apiPresenter struct {
+	Name        string `json:"name"`
+	Affiliation string `json:"affiliation"`
+}
+
+type apiAnnouncement struct {
+	ID       int        `json:"id"`
+	Message  string     `json:"message"`
+	Priority string     `json:"priority"`
+	Start    *time.Time `json:"start,omitempty"`
+	Expiry   *time.Time `json:"expiry,omitempty"`
+	Targets  []string   `json:"targets"`
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+func newAPIAgenda(a Agenda) apiAgenda {
+	o := apiAgenda{
+		Version:       apiVersion,
+		Now:           a.Now,
+		Sessions:      make([]apiSession, 0, len(a.Sessions)),
+		Announcements: make([]apiAnnouncement, 0, len(a.Announcements)),
+	}
+	for _, s := range a.Sessions {
+		sess := apiSession{
+			Title:         s.Title,
+			Room:          s.Room,
+			Start:         s.Start,
+			Stop:          s.Stop,
+			Delay:         seconds(s.Delay),
+			Active:        s.active,
+			Contributions: make([]apiContribution, 0, len(s.Contributions)),
+		}
+		for _, c := range s.Contributions {
+			contr := apiContribution{
+				Title:      c.Title,
+				Start:      c.Start,
+				Stop:       c.Stop,
+				Duration:   seconds(c.Duration),
+				Elapsed:    seconds(c.Elapsed),
+				Remaining:  seconds(c.Remaining),
+				Progress:   c.Progress(),
+				Delay:      seconds(c.Delay),
+				Active:     c.active,
+				Ending:     c.Ending(),
+				Presenters: make([]apiPresenter, 0, len(c.Presenters)),
+			}
+			for _, p := range c.Presenters {
+				contr.Presenters = append(contr.Presenters, apiPresenter{
+					Name:        p.Name,
+					Affiliation: p.Affiliation,
+				})
+			}
+			sess.Contributions = append(sess.Contributions, contr)
+		}
+		o.Sessions = append(o.Sessions, sess)
+	}
+	for _, v := range a.Announcements {
+		ann := apiAnnouncement{
+			ID:       v.ID,
+			Message:  v.Message,
+			Priority: v.Priority.String(),
+			Targets:  v.Targets,
+		}
+		if ann.Targets == nil {
+			ann.Targets = []string{}
+		}
+		if !v.Start.IsZero() {
+			start := v.Start
+			ann.Start = &start
+		}
+		if !v.Expiry.IsZero() {
+			expiry := v.Expiry
+			ann.Expiry = &expiry
+		}
+		o.Announcements = append(o.Announcements, ann)
+	}
+	return o
+}
+
+// stableAPIAgenda returns the JSON representation of a, without the fields
+// which change with the clock only.
+func stableAPIAgenda(a Agenda) apiAgenda {
+	o := newAPIAgenda(a)
+	o.Now = time.Time{}
+	for i := range o.Sessions {
+		for j := range o.Sessions[i].Contributions {
+			c := &o.Sessions[i].Contributions[j]
+			c.Elapsed = 0
+			c.Remaining = 0
+		}
+	}
+	return o
+}
+
+// apiAgendaHandler serves the JSON representation of the agenda.
+// The agenda is the one currently displayed, or the one at the time given by
+// the "at" parameter (see parseTime).
+// Announcements are the ones active now, whatever the time of the agenda.
+func (srv *server) apiAgendaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "invalid http request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	srv.mu.RLock()
+	agenda := srv.agenda
+	srv.mu.RUnlock()
+
+	if at := r.FormValue("at"); at != "" || agenda.Now.IsZero() {
+		now := srv.Now()
+		if at != "" {
+			var err error
+			now, err = parseTime(at, srv.loc)
+			if err != nil {
+				http.Error(w, "invalid time: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		srv.mu.RLock()
+		agenda = newAgenda(now, srv.ttable, srv.delays, srv.trim)
+		agenda.Announcements = srv.activeAnnouncements(srv.wall.Now())
+		srv.mu.RUnlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(newAPIAgenda(agenda))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handshake negotiates the websocket subprotocol.
+// Clients may request the JSON representation of the agenda with the
+// protoJSON subprotocol. Other clients receive HTML fragments.
+func (srv *server) handshake(cfg *websocket.Config, r *http.Request) error {
+	protos := cfg.Protocol
+	cfg.Protocol = nil
+	for _, p := range protos {
+		switch p {
+		case protoHTML, protoJSON:
+			cfg.Protocol = []string{p}
+			return nil
+		}
+	}
+	return nil
+}
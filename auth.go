@@ -0,0 +1,209 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// adminAuth describes how the admin endpoints are protected.
+//
+// Admin requests must come from an allowed network, if any, and carry either
+// the admin token or the admin basic-auth credentials.
+// When no credentials are configured, requests are only accepted from the
+// allowed networks, which must then be given explicitly: the address of the
+// client tells nothing when the server runs behind a reverse proxy, so the
+// admin endpoints are disabled when neither are configured.
+type adminAuth struct {
+	token    string       // shared token
+	user     string       // basic-auth user
+	password string       // basic-auth password
+	allow    []*net.IPNet // allowed networks. empty means all, with credentials.
+}
+
+// newAdminAuth returns the admin authentication layer for the given token,
+// "user:password" basic-auth credentials and comma-separated list of allowed
+// IP addresses or CIDR networks.
+func newAdminAuth(token, basic, allow string) (adminAuth, error) {
+	auth := adminAuth{token: token}
+	if basic != "" {
+		i := strings.Index(basic, ":")
+		if i < 0 {
+			return auth, fmt.Errorf("invalid basic-auth credentials (want user:password)")
+		}
+		auth.user = basic[:i]
+		auth.password = basic[i+1:]
+	}
+	for _, v := range strings.Split(allow, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return auth, fmt.Errorf("invalid IP address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			auth.allow = append(auth.allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			return auth, err
+		}
+		auth.allow = append(auth.allow, ipnet)
+	}
+	return auth, nil
+}
+
+// hasCredentials returns whether admin credentials were configured.
+func (auth adminAuth) hasCredentials() bool {
+	return auth.token != "" || auth.user != ""
+}
+
+// enabled returns whether the admin endpoints are enabled, i.e. whether
+// admin credentials or allowed networks were configured.
+func (auth adminAuth) enabled() bool {
+	return auth.hasCredentials() || len(auth.allow) > 0
+}
+
+// allowed returns whether the request comes from an allowed address.
+func (auth adminAuth) allowed(r *http.Request) bool {
+	ip := remoteIP(r)
+	if ip == nil || !auth.enabled() {
+		return false
+	}
+	if len(auth.allow) == 0 {
+		return true
+	}
+	for _, ipnet := range auth.allow {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticated returns whether the request carries the admin credentials.
+func (auth adminAuth) authenticated(r *http.Request) bool {
+	if !auth.hasCredentials() {
+		return true
+	}
+	if auth.token != "" {
+		tok := r.Header.Get("X-Admin-Token")
+		if v := r.Header.Get("Authorization"); strings.HasPrefix(v, "Bearer ") {
+			tok = strings.TrimPrefix(v, "Bearer ")
+		}
+		if tok != "" && subtle.ConstantTimeCompare([]byte(tok), []byte(auth.token)) == 1 {
+			return true
+		}
+	}
+	if auth.user != "" {
+		user, password, ok := r.BasicAuth()
+		if ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(auth.user)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(auth.password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenHeader returns whether the request carries an admin token in a header,
+// which a cross-site page cannot make a browser send.
+func tokenHeader(r *http.Request) bool {
+	return r.Header.Get("X-Admin-Token") != "" ||
+		strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// sameOrigin returns whether the request may have been sent by a page of the
+// server itself.
+// Browsers send Sec-Fetch-Site, or at least Origin, with unsafe requests:
+// requests carrying neither are not sent by a browser, and cannot be forged
+// by a cross-site page.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// admin wraps an admin handler so it only serves authenticated requests with
+// one of the given methods.
+// Unsafe requests must also carry the admin token in a header, or come from a
+// page of the server, so a cross-site page cannot forge them.
+func (srv *server) admin(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok := false
+		for _, m := range methods {
+			if r.Method == m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !tokenHeader(r) && !sameOrigin(r) {
+				http.Error(w, "cross-origin request", http.StatusForbidden)
+				return
+			}
+		}
+		srv.mu.RLock()
+		auth := srv.auth
+		srv.mu.RUnlock()
+		if !auth.enabled() {
+			http.Error(w, "admin endpoints disabled: no admin credentials configured", http.StatusForbidden)
+			return
+		}
+		if !auth.allowed(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if !auth.authenticated(r) {
+			switch {
+			case auth.user != "":
+				w.Header().Set("WWW-Authenticate", `Basic realm="ji-web-display"`)
+			default:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ji-web-display"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminCrossOrigin(t *testing.T) {
+	srv := newServer(":0", nil, realClock{})
+	auth, err := newAdminAuth("", "", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("could not create admin auth: %+v", err)
+	}
+	srv.auth = auth
+	h := srv.admin(func(w http.ResponseWriter, r *http.Request) {}, http.MethodGet, http.MethodPost)
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{name: "curl", method: http.MethodPost, want: http.StatusOK},
+		{name: "get cross-site", method: http.MethodGet, headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, want: http.StatusOK},
+		{name: "same-origin", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "same-origin"}, want: http.StatusOK},
+		{name: "cross-site", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, want: http.StatusForbidden},
+		{name: "same-site", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "same-site"}, want: http.StatusForbidden},
+		{name: "origin", method: http.MethodPost, headers: map[string]string{"Origin": "http://127.0.0.1:9090"}, want: http.StatusOK},
+		{name: "foreign origin", method: http.MethodPost, headers: map[string]string{"Origin": "http://evil.example.org"}, want: http.StatusForbidden},
+		{name: "null origin", method: http.MethodPost, headers: map[string]string{"Origin": "null"}, want: http.StatusForbidden},
+		{
+			name:    "token header",
+			method:  http.MethodPost,
+			headers: map[string]string{"Sec-Fetch-Site": "cross-site", "X-Admin-Token": "s3cr3t"},
+			want:    http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "http://127.0.0.1:9090/delay", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("invalid status: got=%d, want=%d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestAdminEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     func(cfg *config)
+		addr    string
+		headers map[string]string
+		url     string
+		want    int
+	}{
+		{name: "no credentials", cfg: func(cfg *config) {}, addr: "127.0.0.1", want: http.StatusForbidden},
+		{
+			name: "forwarded",
+			cfg:  func(cfg *config) {},
+			addr: "127.0.0.1",
+			headers: map[string]string{
+				"X-Forwarded-For": "192.168.1.12",
+				"X-Admin-Token":   "s3cr3t",
+			},
+			want: http.StatusForbidden,
+		},
+		{name: "allowed", cfg: func(cfg *config) { cfg.Admin.Allow = "127.0.0.1" }, addr: "127.0.0.1", want: http.StatusOK},
+		{name: "not allowed", cfg: func(cfg *config) { cfg.Admin.Allow = "127.0.0.1" }, addr: "192.168.1.12", want: http.StatusForbidden},
+		{
+			name:    "token",
+			cfg:     func(cfg *config) { cfg.Admin.Token = "s3cr3t" },
+			addr:    "192.168.1.12",
+			headers: map[string]string{"Authorization": "Bearer s3cr3t"},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "invalid token",
+			cfg:     func(cfg *config) { cfg.Admin.Token = "s3cr3t" },
+			addr:    "127.0.0.1",
+			headers: map[string]string{"X-Admin-Token": "guess"},
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name: "query token",
+			cfg:  func(cfg *config) { cfg.Admin.Token = "s3cr3t" },
+			addr: "127.0.0.1",
+			url:  "?token=s3cr3t",
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:    "basic auth",
+			cfg:     func(cfg *config) { cfg.Admin.BasicAuth = "admin:pass" },
+			addr:    "192.168.1.12",
+			headers: map[string]string{"Authorization": "Basic YWRtaW46cGFzcw=="},
+			want:    http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.cfg(&cfg)
+			srv := newServer(":0", nil, realClock{})
+			err := srv.configure(&cfg)
+			if err != nil {
+				t.Fatalf("could not configure server: %+v", err)
+			}
+			h := srv.admin(func(w http.ResponseWriter, r *http.Request) {}, http.MethodGet)
+			req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:9090/displays"+tc.url, nil)
+			req.RemoteAddr = tc.addr + ":1234"
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("invalid status: got=%d, want=%d (%s)", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestAdminLogin(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Admin.Token = "s3cr3t"
+	srv := newServer(":0", testTimeTable(), fixedClock(at(9, 45)))
+	err := srv.configure(&cfg)
+	if err != nil {
+		t.Fatalf("could not configure server: %+v", err)
+	}
+	h := srv.adminLogin(srv.admin(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("console"))
+	}, http.MethodGet))
+
+	// browsers opening the console are asked for the token.
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:9090/admin", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="token"`) {
+		t.Fatalf("invalid login page: %d\n%s", rec.Code, rec.Body)
+	}
+
+	// which is then sent in a header.
+	req = httptest.NewRequest(http.MethodGet, "http://127.0.0.1:9090/admin", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Admin-Token", "s3cr3t")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "console" {
+		t.Fatalf("invalid console: %d\n%s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Clock tells the time of the agenda.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock tells the current time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// fixedClock always tells the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+// offsetClock tells the time of a base clock, shifted by a constant offset.
+type offsetClock struct {
+	base   Clock
+	offset time.Duration
+}
+
+// newOffsetClock returns a clock telling the given time now, and running
+// along the base clock afterwards.
+func newOffsetClock(base Clock, now time.Time) offsetClock {
+	return offsetClock{base: base, offset: now.Sub(base.Now())}
+}
+
+func (c offsetClock) Now() time.Time { return c.base.Now().Add(c.offset) }
+
+// clockSpeed returns how fast a clock runs, relative to the real time.
+func clockSpeed(c Clock) float64 {
+	switch c := c.(type) {
+	case fixedClock:
+		return 0
+	case offsetClock:
+		return clockSpeed(c.base)
+	case *simClock:
+		if c.paused {
+			return 0
+		}
+		return c.speed * clockSpeed(c.base)
+	default:
+		return 1
+	}
+}
+
+// clockSync tells the displays the time of the agenda, so they can run their
+// own clock between the updates of the agenda.
+type clockSync struct {
+	Control string  `json:"control"` // always "sync"
+	Time    int64   `json:"time"`    // time of the agenda, in milliseconds since the Unix epoch
+	Offset  int     `json:"offset"`  // offset of the time zone of the agenda, in seconds east of UTC
+	Speed   float64 `json:"speed"`   // speed of the agenda time, relative to the real time
+}
+
+// at returns the time predicted by s after d of real time.
+func (s clockSync) at(d time.Duration) int64 {
+	return s.Time + int64(float64(d/time.Millisecond)*s.Speed)
+}
+
+// drifted returns whether a display whose clock was synchronized with prev,
+// d of real time ago, drifted from s by more than a second.
+func (s clockSync) drifted(prev clockSync, d time.Duration) bool {
+	return s.Offset != prev.Offset || s.Speed != prev.Speed ||
+		abs(s.Time-prev.at(d)) > 1000
+}
+
+// encode returns the JSON representation of s.
+func (s clockSync) encode() ([]byte, error) {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode clock sync: %w", err)
+	}
+	return buf, nil
+}
+
+// clockSync returns the current time of the agenda for the displays.
+func (srv *server) clockSync() clockSync {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	now := srv.clock.Now()
+	_, offset := now.Zone()
+	return clockSync{
+		Control: "sync",
+		Time:    now.UnixNano() / int64(time.Millisecond),
+		Offset:  offset,
+		Speed:   clockSpeed(srv.clock),
+	}
+}
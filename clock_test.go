@@ -0,0 +1,253 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/clr-info/ji-web-display/indico"
+)
+
+func at(hour, min int) time.Time {
+	return time.Date(2016, 10, 3, hour, min, 0, 0, time.UTC)
+}
+
+func testTimeTable() *indico.TimeTable {
+	contrib := func(title string, beg, end time.Time) indico.Contribution {
+		return indico.Contribution{EntryID: indico.EntryID{
+			Title:     title,
+			StartDate: beg,
+			EndDate:   end,
+			Duration:  end.Sub(beg),
+		}}
+	}
+	return &indico.TimeTable{
+		ID: 1,
+		Days: []indico.Day{{
+			Date: at(0, 0),
+			Sessions: []indico.Session{
+				{
+					EntryID: indico.EntryID{ID: "s1", Title: "Morning", Room: "Amphi", StartDate: at(9, 0), EndDate: at(10, 0)},
+					Contributions: []indico.Contribution{
+						contrib("A", at(9, 0), at(9, 30)),
+						contrib("B", at(9, 30), at(10, 0)),
+					},
+				},
+				{
+					EntryID: indico.EntryID{ID: "s2", Title: "Noon", Room: "Amphi", StartDate: at(10, 30), EndDate: at(12, 0)},
+					Contributions: []indico.Contribution{
+						contrib("C", at(10, 30), at(11, 0)),
+						contrib("D", at(11, 0), at(12, 0)),
+					},
+				},
+			},
+		}},
+	}
+}
+
+func TestSimClock(t *testing.T) {
+	origin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock, err := newSimClock(fixedClock(origin), testTimeTable(), time.Time{}, time.Time{}, 60)
+	if err != nil {
+		t.Fatalf("could not create clock: %+v", err)
+	}
+
+	// advance moves the base clock of c by d of real time.
+	advance := func(c *simClock, d time.Duration) {
+		c.base = fixedClock(c.base.Now().Add(d))
+	}
+	// check compares the speed of the simulation itself: the fixed base
+	// clock does not run, so clockSpeed(c) is always 0 here.
+	check := func(c *simClock, want time.Time, speed float64) {
+		t.Helper()
+		if got := c.Now(); !got.Equal(want) {
+			t.Fatalf("invalid time: got=%v, want=%v", got, want)
+		}
+		got := c.speed
+		if c.paused {
+			got = 0
+		}
+		if got != speed {
+			t.Fatalf("invalid speed: got=%v, want=%v", got, speed)
+		}
+	}
+
+	check(clock, at(9, 0), 60)
+	advance(clock, 30*time.Second)
+	check(clock, at(9, 30), 60)
+
+	advance(clock, 45*time.Second)
+	check(clock, at(10, 15), 60)
+
+	// the simulation loops back to the start of the first day, after the
+	// end of the last one.
+	advance(clock, 135*time.Second)
+	check(clock, at(9, 30), 60)
+
+	paused := clock.pause()
+	check(paused, at(9, 30), 0)
+	advance(paused, time.Hour)
+	check(paused, at(9, 30), 0)
+
+	moved := paused.seek(at(11, 0))
+	check(moved, at(11, 0), 0)
+
+	// times outside of the days of the timetable are moved to the start
+	// of the next day.
+	moved = moved.seek(at(8, 0))
+	check(moved, at(9, 0), 0)
+
+	resumed := moved.resume()
+	check(resumed, at(9, 0), 60)
+	advance(resumed, 10*time.Second)
+	check(resumed, at(9, 10), 60)
+
+	slow := resumed.withSpeed(6)
+	advance(slow, 10*time.Second)
+	check(slow, at(9, 11), 6)
+
+	// the original clock is left untouched.
+	check(clock, at(9, 30), 60)
+
+	// the displays are told the speed of the simulation relative to the
+	// real time.
+	for _, tc := range []struct {
+		c    *simClock
+		want float64
+	}{
+		{c: clock, want: 0},
+		{c: &simClock{base: realClock{}, speed: 60}, want: 60},
+		{c: &simClock{base: realClock{}, speed: 60, paused: true}, want: 0},
+	} {
+		if got := clockSpeed(tc.c); got != tc.want {
+			t.Fatalf("invalid clock speed: got=%v, want=%v", got, tc.want)
+		}
+	}
+}
+
+func TestClockSyncDrift(t *testing.T) {
+	sync := func(c Clock) clockSync {
+		now := c.Now()
+		_, offset := now.Zone()
+		return clockSync{
+			Control: "sync",
+			Time:    now.UnixNano() / int64(time.Millisecond),
+			Offset:  offset,
+			Speed:   clockSpeed(c),
+		}
+	}
+	sim, err := newSimClock(realClock{}, testTimeTable(), time.Time{}, time.Time{}, 60)
+	if err != nil {
+		t.Fatalf("could not create clock: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		prev, cur Clock
+		elapsed   time.Duration
+		want      bool
+	}{
+		{
+			name:    "real time",
+			prev:    newOffsetClock(realClock{}, at(9, 0)),
+			cur:     newOffsetClock(realClock{}, at(9, 5)),
+			elapsed: 5 * time.Minute,
+			want:    false,
+		},
+		{
+			name:    "jump",
+			prev:    newOffsetClock(realClock{}, at(9, 0)),
+			cur:     newOffsetClock(realClock{}, at(9, 7)),
+			elapsed: 5 * time.Minute,
+			want:    true,
+		},
+		{
+			name:    "pause",
+			prev:    sim,
+			cur:     sim.pause(),
+			elapsed: 0,
+			want:    true,
+		},
+		{
+			name:    "paused",
+			prev:    sim.pause(),
+			cur:     sim.pause(),
+			elapsed: time.Hour,
+			want:    false,
+		},
+		{
+			name:    "speed",
+			prev:    sim,
+			cur:     sim.withSpeed(2),
+			elapsed: 0,
+			want:    true,
+		},
+		{
+			name:    "time zone",
+			prev:    fixedClock(at(9, 0)),
+			cur:     fixedClock(at(9, 0).In(time.FixedZone("CEST", 2*3600))),
+			elapsed: 0,
+			want:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := sync(tc.cur).drifted(sync(tc.prev), tc.elapsed)
+			if got != tc.want {
+				t.Fatalf("invalid drift: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSimClockWithTable(t *testing.T) {
+	origin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock, err := newSimClock(fixedClock(origin), testTimeTable(), time.Time{}, time.Time{}, 60)
+	if err != nil {
+		t.Fatalf("could not create clock: %+v", err)
+	}
+	clock = clock.seek(at(11, 30)).pause()
+
+	// the new timetable ends earlier: the simulation moves to the next
+	// day of the new timetable, here the first one.
+	tbl := testTimeTable()
+	tbl.Days[0].Sessions = tbl.Days[0].Sessions[:1]
+	moved, err := clock.withTable(tbl)
+	if err != nil {
+		t.Fatalf("could not update clock: %+v", err)
+	}
+	if got, want := moved.Now(), at(9, 0); !got.Equal(want) {
+		t.Fatalf("invalid time: got=%v, want=%v", got, want)
+	}
+	if !moved.paused || moved.speed != 60 {
+		t.Fatalf("invalid state: paused=%v, speed=%v", moved.paused, moved.speed)
+	}
+
+	// times still within the new timetable are kept.
+	clock = clock.seek(at(9, 45))
+	kept, err := clock.withTable(tbl)
+	if err != nil {
+		t.Fatalf("could not update clock: %+v", err)
+	}
+	if got, want := kept.Now(), at(9, 45); !got.Equal(want) {
+		t.Fatalf("invalid time: got=%v, want=%v", got, want)
+	}
+}
+
+func TestClockSyncEncode(t *testing.T) {
+	buf, err := clockSync{Control: "sync", Time: 1000, Offset: 3600, Speed: 2}.encode()
+	if err != nil {
+		t.Fatalf("could not encode clock: %+v", err)
+	}
+	if got, want := string(buf), `{"control":"sync","time":1000,"offset":3600,"speed":2}`; got != want {
+		t.Fatalf("invalid JSON:\ngot= %s\nwant=%s", got, want)
+	}
+
+	_, err = clockSync{Control: "sync", Speed: math.Inf(+1)}.encode()
+	if err == nil {
+		t.Fatalf("expected an error encoding an infinite speed")
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// nowLayout is the layout of the times of the configuration.
+const nowLayout = "2006-01-02 15:04:05"
+
+// config is the configuration of the server.
+//
+// It is read from a JSON file, whose fields are overridden by the JI_*
+// environment variables, themselves overridden by the command line flags.
+// Each flag has its environment variable, named after it (e.g. JI_ADMIN_TOKEN
+// for -admin-token).
+type config struct {
+	Addr   string `json:"addr"`   // [hostname|ip]:port of the web server
+	Event  int    `json:"event"`  // Indico event id
+	Indico string `json:"indico"` // host of the Indico server
+	Now    string `json:"now"`    // agenda time, in the nowLayout format
+	Loc    string `json:"loc"`    // agenda time location
+
+	Sim struct {
+		Enabled bool    `json:"enabled"`
+		Start   string  `json:"start"` // in the nowLayout format
+		End     string  `json:"end"`   // in the nowLayout format
+		Speed   float64 `json:"speed"`
+	} `json:"sim"`
+
+	Tick duration  `json:"tick"` // period of the renderings of the agenda
+	Trim trimSizes `json:"trim"`
+
+	Lang            string             `json:"lang"`
+	Theme           string             `json:"theme"`
+	Templates       string             `json:"templates"`
+	ReloadTemplates bool               `json:"reload_templates"`
+	Themes          map[string]string  `json:"themes"`   // stylesheet files, per theme
+	Displays        map[string]profile `json:"displays"` // profiles, per display name
+
+	BaseURL string `json:"base_url"`
+	TLS     struct {
+		Cert       string `json:"cert"`
+		Key        string `json:"key"`
+		SelfSigned bool   `json:"self_signed"`
+	} `json:"tls"`
+	RedirectAddr string `json:"redirect_addr"`
+
+	Admin struct {
+		Token     string `json:"token"`
+		BasicAuth string `json:"basic_auth"` // user:password
+		Allow     string `json:"allow"`      // comma-separated list of IP addresses or CIDR networks
+	} `json:"admin"`
+
+	Log struct {
+		Level  string `json:"level"`
+		Format string `json:"format"`
+	} `json:"log"`
+}
+
+// profile holds the settings of a display, applied when the display does
+// not give them as URL parameters.
+type profile struct {
+	Lang   string `json:"lang"`
+	Theme  string `json:"theme"`
+	Room   string `json:"room"`
+	Screen string `json:"screen"`
+}
+
+// duration is a time.Duration written as a string in JSON (e.g. "1s").
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var v string
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	dt, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = duration(dt)
+	return nil
+}
+
+func defaultConfig() config {
+	var cfg config
+	cfg.Addr = ":80"
+	cfg.Event = 12779
+	cfg.Indico = "indico.in2p3.fr"
+	cfg.Loc = "Europe/Paris"
+	cfg.Sim.Speed = 60
+	cfg.Tick = duration(1 * time.Second)
+	cfg.Trim = defaultTrim
+	cfg.Lang = "en"
+	cfg.Theme = "default"
+	cfg.BaseURL = "/"
+	cfg.Log.Level = "info"
+	cfg.Log.Format = "text"
+	return cfg
+}
+
+// flags defines the command line flags setting the fields of cfg, with the
+// values of cfg as defaults.
+func (cfg *config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "[hostname|ip]:port for web server")
+	fs.IntVar(&cfg.Event, "evtid", cfg.Event, "event id")
+	fs.StringVar(&cfg.Indico, "indico", cfg.Indico, "host of the Indico server")
+	fs.StringVar(&cfg.Now, "now", cfg.Now, "agenda time. format="+nowLayout)
+	fs.StringVar(&cfg.Loc, "loc", cfg.Loc, "agenda time location")
+	fs.BoolVar(&cfg.Sim.Enabled, "sim", cfg.Sim.Enabled, "enable simulation mode, looping over the event dates")
+	fs.StringVar(&cfg.Sim.Start, "sim-start", cfg.Sim.Start, "start of the simulation (default: start of the event). format="+nowLayout)
+	fs.StringVar(&cfg.Sim.End, "sim-end", cfg.Sim.End, "end of the simulation (default: end of the event). format="+nowLayout)
+	fs.Float64Var(&cfg.Sim.Speed, "sim-speed", cfg.Sim.Speed, "speed factor of the simulation")
+	fs.DurationVar((*time.Duration)(&cfg.Tick), "tick", time.Duration(cfg.Tick), "period of the renderings of the agenda")
+	fs.IntVar(&cfg.Trim.Past, "trim-past", cfg.Trim.Past, "number of past sessions displayed")
+	fs.IntVar(&cfg.Trim.Contributions, "trim-contributions", cfg.Trim.Contributions, "number of contributions of an active session displayed")
+	fs.IntVar(&cfg.Trim.Future, "trim-future", cfg.Trim.Future, "number of sessions displayed from the active one")
+	fs.StringVar(&cfg.Lang, "lang", cfg.Lang, "default display language "+strings.Join(localeNames(), "|"))
+	fs.StringVar(&cfg.Theme, "theme", cfg.Theme, "default display theme")
+	fs.StringVar(&cfg.Templates, "templates", cfg.Templates, "directory of templates (*.tmpl) and themes (*.css) overriding the built-in ones")
+	fs.BoolVar(&cfg.ReloadTemplates, "reload-templates", cfg.ReloadTemplates, "reload templates when they are modified (development)")
+	fs.StringVar(&cfg.BaseURL, "base-url", cfg.BaseURL, "external base URL or path prefix of the server (e.g. /ji/ or https://example.org/ji/)")
+	fs.StringVar(&cfg.TLS.Cert, "tls-cert", cfg.TLS.Cert, "TLS certificate file (enables HTTPS)")
+	fs.StringVar(&cfg.TLS.Key, "tls-key", cfg.TLS.Key, "TLS private key file")
+	fs.BoolVar(&cfg.TLS.SelfSigned, "tls-self-signed", cfg.TLS.SelfSigned, "generate a self-signed TLS certificate (written to -tls-cert/-tls-key when given and missing)")
+	fs.StringVar(&cfg.RedirectAddr, "redirect-addr", cfg.RedirectAddr, "[hostname|ip]:port for a web server redirecting HTTP requests to HTTPS")
+	fs.StringVar(&cfg.Admin.Token, "admin-token", cfg.Admin.Token, "token accepted by the admin endpoints")
+	fs.StringVar(&cfg.Admin.BasicAuth, "admin-basic-auth", cfg.Admin.BasicAuth, "user:password accepted by the admin endpoints")
+	fs.StringVar(&cfg.Admin.Allow, "admin-allow", cfg.Admin.Allow, "comma-separated list of IP addresses or CIDR networks allowed to use the admin endpoints")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "minimum level of the logged messages debug|info|warn|error")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "format of the log text|json")
+}
+
+// envName returns the name of the environment variable overriding a flag.
+func envName(flag string) string {
+	return "JI_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// loadConfig loads the configuration from the given file, if any, the
+// environment and the given command line arguments, and validates it.
+func loadConfig(fname string, args []string) (config, error) {
+	cfg := defaultConfig()
+	if fname != "" {
+		buf, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return cfg, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("could not decode %q: %v", fname, err)
+		}
+	}
+
+	fs := flag.NewFlagSet("ji-web-display", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.String("config", "", "")
+	cfg.flags(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil || f.Name == "config" {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("invalid %s: %v", envName(f.Name), e)
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// validate checks the consistency of the configuration.
+// The themes of the configuration are checked once the templates are loaded.
+func (cfg *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(cfg.Addr)
+	check(err == nil, "invalid address %q: %v", cfg.Addr, err)
+	if cfg.RedirectAddr != "" {
+		_, _, err = net.SplitHostPort(cfg.RedirectAddr)
+		check(err == nil, "invalid redirect address %q: %v", cfg.RedirectAddr, err)
+		check(cfg.TLS.Cert != "" || cfg.TLS.SelfSigned, "redirect address requires HTTPS")
+	}
+	check(cfg.TLS.SelfSigned || (cfg.TLS.Cert == "") == (cfg.TLS.Key == ""), "TLS certificate and key must be given together")
+	_, err = url.Parse(cfg.BaseURL)
+	check(err == nil, "invalid base URL %q: %v", cfg.BaseURL, err)
+
+	check(cfg.Event > 0, "invalid event id %d", cfg.Event)
+	check(cfg.Indico != "", "missing Indico host")
+
+	loc, err := time.LoadLocation(cfg.Loc)
+	check(err == nil, "invalid location %q: %v", cfg.Loc, err)
+	if err == nil {
+		for _, v := range []string{cfg.Now, cfg.Sim.Start, cfg.Sim.End} {
+			if v == "" {
+				continue
+			}
+			_, err = time.ParseInLocation(nowLayout, v, loc)
+			check(err == nil, "invalid time %q (want %s)", v, nowLayout)
+		}
+	}
+	check(validSpeed(cfg.Sim.Speed), "invalid simulation speed %v", cfg.Sim.Speed)
+
+	check(cfg.Tick > 0, "invalid tick %v", time.Duration(cfg.Tick))
+	check(cfg.Trim.Past >= 0, "invalid number of past sessions %d", cfg.Trim.Past)
+	check(cfg.Trim.Contributions > 0, "invalid number of contributions %d", cfg.Trim.Contributions)
+	check(cfg.Trim.Future > 0, "invalid number of future sessions %d", cfg.Trim.Future)
+
+	_, ok := locales[cfg.Lang]
+	check(ok, "invalid display language %q", cfg.Lang)
+	for name, p := range cfg.Displays {
+		_, ok := locales[p.Lang]
+		check(p.Lang == "" || ok, "invalid language %q of display %q", p.Lang, name)
+	}
+
+	_, err = newAdminAuth(cfg.Admin.Token, cfg.Admin.BasicAuth, cfg.Admin.Allow)
+	check(err == nil, "invalid admin credentials: %v", err)
+
+	_, err = newLogger(ioutil.Discard, cfg.Log.Format, cfg.Log.Level)
+	check(err == nil, "%v", err)
+
+	return errors.Join(errs...)
+}
+
+// restartOnly lists the fields of the configuration which are only applied
+// when the server starts.
+var restartOnly = []string{
+	"Addr", "Now", "Loc", "Sim", "Templates", "ReloadTemplates",
+	"BaseURL", "TLS", "RedirectAddr",
+}
+
+// restartDiff returns the JSON names of the fields only applied when the
+// server starts which differ between cfg and o.
+func (cfg *config) restartDiff(o *config) []string {
+	var diff []string
+	v1 := reflect.ValueOf(cfg).Elem()
+	v2 := reflect.ValueOf(o).Elem()
+	for _, name := range restartOnly {
+		if !reflect.DeepEqual(v1.FieldByName(name).Interface(), v2.FieldByName(name).Interface()) {
+			f, _ := v1.Type().FieldByName(name)
+			diff = append(diff, f.Tag.Get("json"))
+		}
+	}
+	return diff
+}
+
+// target returns the display command targeting a display at the room and
+// screen of the profile.
+func (p profile) target() []byte {
+	data, err := json.Marshal(displayCommand{Control: "target", Room: p.Room, Screen: p.Screen})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// profile returns the profile of the display with the given name.
+func (srv *server) profile(name string) profile {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.profiles[name]
+}
+
+// configure applies the settings of cfg which may be changed while the
+// server runs.
+// Nothing is applied when cfg is not valid.
+func (srv *server) configure(cfg *config) error {
+	auth, err := newAdminAuth(cfg.Admin.Token, cfg.Admin.BasicAuth, cfg.Admin.Allow)
+	if err != nil {
+		return err
+	}
+	if !auth.enabled() {
+		slog.Warn("admin endpoints disabled: no admin credentials nor allowed networks configured")
+	}
+
+	css := make(map[string]string, len(cfg.Themes))
+	for name, fname := range cfg.Themes {
+		buf, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return fmt.Errorf("could not read theme %q: %v", name, err)
+		}
+		css[name] = string(buf)
+	}
+
+	srv.tmu.RLock()
+	dir := srv.tmplDir
+	srv.tmu.RUnlock()
+	tmpls, themes, err := parseTemplates(dir, srv.funcs())
+	if err != nil {
+		return err
+	}
+	for name, v := range css {
+		themes[name] = v
+	}
+	if _, ok := themes[cfg.Theme]; !ok {
+		return fmt.Errorf("invalid display theme %q", cfg.Theme)
+	}
+	for name, p := range cfg.Displays {
+		if _, ok := themes[p.Theme]; p.Theme != "" && !ok {
+			return fmt.Errorf("invalid theme %q of display %q", p.Theme, name)
+		}
+	}
+
+	srv.tmu.Lock()
+	srv.tmpls = tmpls
+	srv.themes = themes
+	srv.css = css
+	srv.lang = cfg.Lang
+	srv.theme = cfg.Theme
+	srv.tmu.Unlock()
+
+	srv.mu.Lock()
+	srv.auth = auth
+	srv.indico = cfg.Indico
+	srv.tick = time.Duration(cfg.Tick)
+	srv.trim = cfg.Trim
+	srv.profiles = cfg.Displays
+	srv.mu.Unlock()
+	srv.kick()
+	return nil
+}
+
+// reload applies cfg, replacing the prev configuration, to the running
+// server.
+// The displays stay connected: the ones whose profile changed are told to
+// reload, re-theme or re-target.
+// reload returns whether cfg was applied, which it is even when the displays
+// could not be told or the timetable of a new event could not be fetched.
+// In the latter case, cfg is updated to keep the event and Indico host of
+// prev.
+func (srv *server) reload(prev, cfg *config) (bool, error) {
+	if diff := prev.restartDiff(cfg); len(diff) > 0 {
+		slog.Warn("configuration changes applied at restart only", "fields", diff)
+	}
+
+	err := srv.configure(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	names := make(map[string]bool)
+	for name := range prev.Displays {
+		names[name] = true
+	}
+	for name := range cfg.Displays {
+		names[name] = true
+	}
+	for name := range names {
+		old, p := prev.Displays[name], cfg.Displays[name]
+		var cmds []displayCommand
+		switch {
+		case old.Lang != p.Lang:
+			cmds = append(cmds, displayCommand{Control: "reload"})
+		case old.Theme != p.Theme:
+			theme := p.Theme
+			if theme == "" {
+				theme = cfg.Theme
+			}
+			cmds = append(cmds, displayCommand{Control: "theme", Theme: theme})
+		}
+		if old.Room != p.Room || old.Screen != p.Screen {
+			cmds = append(cmds, displayCommand{Control: "target", Room: p.Room, Screen: p.Screen})
+		}
+		for _, cmd := range cmds {
+			n, err := srv.send(name, cmd)
+			if err != nil {
+				return true, err
+			}
+			slog.Info("command sent", "command", cmd.Control, "display", name, "connections", n)
+		}
+	}
+
+	if cfg.Event != prev.Event || cfg.Indico != prev.Indico {
+		err = srv.refreshTable(cfg.Event)
+		if err != nil {
+			// the previous timetable is still displayed: cfg keeps the
+			// event and Indico host of prev, so the next reload fetches
+			// the new timetable again.
+			srv.mu.Lock()
+			srv.indico = prev.Indico
+			srv.mu.Unlock()
+			cfg.Event, cfg.Indico = prev.Event, prev.Indico
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,238 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		file string // content of the configuration file, if any
+		env  map[string]string
+		args []string
+		want func(cfg *config) // changes to the default configuration
+		err  string
+	}{
+		{
+			name: "defaults",
+			want: func(cfg *config) {},
+		},
+		{
+			name: "file",
+			file: `{"event": 1, "sim": {"speed": 2}, "admin": {"token": "file"}}`,
+			want: func(cfg *config) {
+				cfg.Event = 1
+				cfg.Sim.Speed = 2
+				cfg.Admin.Token = "file"
+			},
+		},
+		{
+			name: "env over file",
+			file: `{"event": 1, "admin": {"token": "file"}}`,
+			env:  map[string]string{"JI_EVTID": "2"},
+			want: func(cfg *config) {
+				cfg.Event = 2
+				cfg.Admin.Token = "file"
+			},
+		},
+		{
+			name: "flags over env",
+			file: `{"event": 1}`,
+			env:  map[string]string{"JI_EVTID": "2"},
+			args: []string{"-evtid=3"},
+			want: func(cfg *config) { cfg.Event = 3 },
+		},
+		{
+			name: "env names",
+			env: map[string]string{
+				"JI_ADMIN_TOKEN":      "env",
+				"JI_ADMIN_BASIC_AUTH": "admin:pass",
+				"JI_SIM":              "true",
+				"JI_SIM_SPEED":        "600",
+				"JI_TRIM_PAST":        "2",
+				"JI_LOG_LEVEL":        "debug",
+				"JI_TICK":             "5s",
+			},
+			want: func(cfg *config) {
+				cfg.Admin.Token = "env"
+				cfg.Admin.BasicAuth = "admin:pass"
+				cfg.Sim.Enabled = true
+				cfg.Sim.Speed = 600
+				cfg.Trim.Past = 2
+				cfg.Log.Level = "debug"
+				cfg.Tick = duration(5 * time.Second)
+			},
+		},
+		{
+			name: "invalid env",
+			env:  map[string]string{"JI_EVTID": "twelve"},
+			err:  "invalid JI_EVTID",
+		},
+		{
+			name: "unknown field",
+			file: `{"evt": 1}`,
+			err:  `unknown field "evt"`,
+		},
+		{
+			name: "invalid flag",
+			args: []string{"-evtid=0"},
+			err:  "invalid event id 0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			var fname string
+			if tc.file != "" {
+				fname = filepath.Join(t.TempDir(), "config.json")
+				err := ioutil.WriteFile(fname, []byte(tc.file), 0644)
+				if err != nil {
+					t.Fatalf("could not write configuration: %+v", err)
+				}
+			}
+
+			cfg, err := loadConfig(fname, tc.args)
+			switch {
+			case tc.err != "":
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("invalid error: got=%v, want=%q", err, tc.err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not load configuration: %+v", err)
+			}
+
+			want := defaultConfig()
+			tc.want(&want)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Fatalf("invalid configuration:\ngot= %+v\nwant=%+v", cfg, want)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := defaultConfig()
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("invalid default configuration: %+v", err)
+	}
+
+	// all the errors are reported at once.
+	cfg.Event = 0
+	cfg.Indico = ""
+	cfg.Lang = "xx"
+	cfg.Tick = 0
+	err := cfg.validate()
+	if err == nil {
+		t.Fatalf("expected an invalid configuration")
+	}
+	errs, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("invalid error type %T", err)
+	}
+	if got, want := len(errs.Unwrap()), 4; got != want {
+		t.Fatalf("invalid number of errors: got=%d, want=%d (%v)", got, want, err)
+	}
+	for _, want := range []string{
+		"invalid event id 0",
+		"missing Indico host",
+		`invalid display language "xx"`,
+		"invalid tick 0s",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q: %v", want, err)
+		}
+	}
+}
+
+func TestRestartDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(cfg *config)
+		want   []string
+	}{
+		{name: "same", change: func(cfg *config) {}},
+		{name: "addr", change: func(cfg *config) { cfg.Addr = ":8080" }, want: []string{"addr"}},
+		{name: "sim", change: func(cfg *config) { cfg.Sim.Speed = 2 }, want: []string{"sim"}},
+		{
+			name: "tls and base url",
+			change: func(cfg *config) {
+				cfg.TLS.Cert = "cert.pem"
+				cfg.BaseURL = "/ji/"
+			},
+			want: []string{"base_url", "tls"},
+		},
+		{
+			name: "reloadable",
+			change: func(cfg *config) {
+				cfg.Event = 1
+				cfg.Lang = "fr"
+				cfg.Displays = map[string]profile{"hall": {Theme: "dark"}}
+				cfg.Admin.Token = "s3cr3t"
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := defaultConfig()
+			cfg := defaultConfig()
+			tc.change(&cfg)
+			got := prev.restartDiff(&cfg)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid diff: got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReloadEventFailure(t *testing.T) {
+	srv := newServer(":0", testTimeTable(), fixedClock(at(9, 45)))
+	prev := defaultConfig()
+	prev.Admin.Allow = "127.0.0.1"
+	err := srv.configure(&prev)
+	if err != nil {
+		t.Fatalf("could not configure server: %+v", err)
+	}
+
+	// an Indico host refusing connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+	host := ln.Addr().String()
+	ln.Close()
+
+	next := prev
+	next.Event = 42
+	next.Indico = host
+	next.Trim.Past = 2
+	applied, err := srv.reload(&prev, &next)
+	if !applied || err == nil {
+		t.Fatalf("invalid reload: applied=%v, err=%v", applied, err)
+	}
+
+	// the other settings are applied, but the event is kept so the next
+	// reload fetches it again.
+	if next.Event != prev.Event || next.Indico != prev.Indico {
+		t.Fatalf("invalid event: got=%d@%s, want=%d@%s", next.Event, next.Indico, prev.Event, prev.Indico)
+	}
+	srv.mu.RLock()
+	indico, trim := srv.indico, srv.trim
+	srv.mu.RUnlock()
+	if indico != prev.Indico {
+		t.Fatalf("invalid Indico host: got=%q, want=%q", indico, prev.Indico)
+	}
+	if trim.Past != 2 {
+		t.Fatalf("invalid trim: got=%+v", trim)
+	}
+}
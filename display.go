@@ -0,0 +1,128 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// clientInfo describes a connected display.
+type clientInfo struct {
+	Name      string    `json:"name"`      // name or generated ID of the display
+	Addr      string    `json:"addr"`      // remote address
+	Agent     string    `json:"agent"`     // user agent
+	Transport string    `json:"transport"` // websocket, sse or poll
+	Lang      string    `json:"lang"`
+	JSON      bool      `json:"json"`
+	Since     time.Time `json:"since"`    // connection time
+	LastAck   time.Time `json:"last_ack"` // last sign of life of the display
+	Dropped   uint64    `json:"dropped"`  // messages replaced before being sent
+}
+
+// clients returns the displays currently connected.
+func (srv *server) clients() []clientInfo {
+	req := make(chan []clientInfo, 1)
+	select {
+	case srv.reg.list <- req:
+	case <-srv.reg.done:
+		return nil
+	}
+	o := <-req
+	sort.Slice(o, func(i, j int) bool { return o[i].Since.Before(o[j].Since) })
+	return o
+}
+
+// displayCommand is a command sent by an admin to a display.
+// It is sent to the display as a JSON object, in place of an agenda.
+type displayCommand struct {
+	Control string `json:"control"` // reload, theme or target
+	Theme   string `json:"theme,omitempty"`
+	Room    string `json:"room,omitempty"`
+	Screen  string `json:"screen,omitempty"`
+}
+
+// command is a displayCommand addressed to the displays with a given name.
+type command struct {
+	name string
+	data []byte
+	n    chan int // number of displays the command was sent to
+}
+
+// send sends cmd to the displays with the given name, and returns the number
+// of displays it was sent to.
+func (srv *server) send(name string, cmd displayCommand) (int, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, err
+	}
+	req := command{name: name, data: data, n: make(chan int, 1)}
+	select {
+	case srv.reg.command <- req:
+	case <-srv.reg.done:
+		return 0, fmt.Errorf("server shutting down")
+	}
+	return <-req.n, nil
+}
+
+// displaysHandler serves the JSON list of the connected displays.
+func (srv *server) displaysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(srv.clients())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// displayHandler remotely controls the displays whose name is given by the
+// "name" form value.
+// The "action" form value selects the command:
+//   - reload: reload the page,
+//   - theme: switch to the theme given by the "theme" form value,
+//   - target: target the displays at the "room" and "screen" form values.
+func (srv *server) displayHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "missing display name", http.StatusBadRequest)
+		return
+	}
+
+	cmd := displayCommand{Control: r.FormValue("action")}
+	switch cmd.Control {
+	case "reload":
+	case "theme":
+		cmd.Theme = r.FormValue("theme")
+		srv.tmu.RLock()
+		_, ok := srv.themes[cmd.Theme]
+		srv.tmu.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid theme %q", cmd.Theme), http.StatusBadRequest)
+			return
+		}
+	case "target":
+		cmd.Room = strings.TrimSpace(r.FormValue("room"))
+		cmd.Screen = strings.TrimSpace(r.FormValue("screen"))
+	default:
+		http.Error(w, fmt.Sprintf("invalid action %q", cmd.Control), http.StatusBadRequest)
+		return
+	}
+
+	n, err := srv.send(name, cmd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, fmt.Sprintf("no display %q", name), http.StatusNotFound)
+		return
+	}
+	slog.Info("command sent", "command", cmd.Control, "display", name, "connections", n)
+	fmt.Fprintf(w, "%s command sent to display %q (%d connection(s))\n", cmd.Control, name, n)
+}
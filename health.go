@@ -0,0 +1,82 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// healthSlack is the time left to the crawler to render the agenda, on top
+// of 3 of its ticks, before it is considered stuck.
+const healthSlack = 7 * time.Second
+
+// health is the JSON representation of the liveness of the server.
+type health struct {
+	Status   string    `json:"status"`    // ok, or the reason why the server is not healthy
+	LastTick time.Time `json:"last_tick"` // time of the last rendering of the agenda
+}
+
+// readiness is the JSON representation of the readiness of the server.
+type readiness struct {
+	Status string    `json:"status"` // ok, or the reason why the server is not ready
+	Event  int       `json:"event"`
+	Source string    `json:"source"` // indico, or embedded for the timetable built in the server
+	Loaded time.Time `json:"loaded"` // time at which the timetable was loaded
+	Age    int64     `json:"age"`    // age of the timetable, in seconds
+}
+
+// healthzHandler reports whether the server is alive, i.e. whether the
+// crawler rendered the agenda recently.
+func (srv *server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	h := health{
+		Status:   "ok",
+		LastTick: time.Unix(0, srv.ticked.Load()),
+	}
+	srv.mu.RLock()
+	timeout := 3*srv.tick + healthSlack
+	srv.mu.RUnlock()
+
+	code := http.StatusOK
+	if d := time.Since(h.LastTick); d > timeout {
+		h.Status = "crawler stuck for " + d.Round(time.Second).String()
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, h)
+}
+
+// readyzHandler reports whether the server is ready to serve the displays,
+// i.e. whether a timetable is loaded, along with its origin and age.
+func (srv *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	var h readiness
+	srv.mu.RLock()
+	if srv.ttable != nil {
+		h.Event = srv.ttable.ID
+		h.Source = srv.source
+		h.Loaded = srv.loaded
+		h.Age = int64(time.Since(srv.loaded) / time.Second)
+	}
+	srv.mu.RUnlock()
+
+	h.Status = "ok"
+	code := http.StatusOK
+	select {
+	case <-srv.reg.done:
+		h.Status, code = "shutting down", http.StatusServiceUnavailable
+	default:
+		if h.Loaded.IsZero() {
+			h.Status, code = "no timetable loaded", http.StatusServiceUnavailable
+		}
+	}
+	writeHealth(w, code, h)
+}
+
+func writeHealth(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
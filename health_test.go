@@ -0,0 +1,38 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		tick  time.Duration
+		since time.Duration // time since the last tick
+		want  int
+	}{
+		{name: "ok", tick: time.Second, since: time.Second, want: http.StatusOK},
+		{name: "stuck", tick: time.Second, since: 11 * time.Second, want: http.StatusServiceUnavailable},
+		{name: "slow tick", tick: time.Minute, since: 2 * time.Minute, want: http.StatusOK},
+		{name: "stuck slow tick", tick: time.Minute, since: 4 * time.Minute, want: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newServer(":0", nil, realClock{})
+			srv.tick = tc.tick
+			srv.ticked.Store(time.Now().Add(-tc.since).UnixNano())
+
+			rec := httptest.NewRecorder()
+			srv.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != tc.want {
+				t.Fatalf("invalid status: got=%d, want=%d (%s)", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -172,6 +173,8 @@ func FetchTimeTable(host string, evtid int) (*TimeTable, error) {
 		host, evtid,
 	)
 
+	start := time.Now()
+	slog.Debug("fetching timetable", "event", evtid, "url", url)
 	resp, err := http.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("could not GET timetable: %v", err)
@@ -188,6 +191,13 @@ func FetchTimeTable(host string, evtid int) (*TimeTable, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal JSON response: %v", err)
 	}
+	slog.Debug("timetable fetched",
+		"event", evtid,
+		"status", resp.StatusCode,
+		"size", len(buf),
+		"days", len(tbl.Days),
+		"duration", time.Since(start),
+	)
 
 	return &tbl, err
 }
@@ -0,0 +1,165 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// locale describes how dates, times, durations and UI strings are displayed.
+type locale struct {
+	Name string
+	Date string // layout of numerical dates
+	Time string // layout of times of day
+
+	weekdays [7]string  // names of the days of the week, starting on Sunday
+	months   [12]string // names of the months, starting in January
+	dayFmt   func(loc *locale, t time.Time, short bool) string
+	hourMin  string            // format of durations with hours and minutes
+	strings  map[string]string // translations of the UI strings
+}
+
+var locales = map[string]*locale{
+	"en": {
+		Name: "en",
+		Date: "2006-01-02",
+		Time: "15:04",
+		weekdays: [7]string{
+			"Sunday", "Monday", "Tuesday", "Wednesday",
+			"Thursday", "Friday", "Saturday",
+		},
+		months: [12]string{
+			"January", "February", "March", "April", "May", "June",
+			"July", "August", "September", "October", "November", "December",
+		},
+		dayFmt: func(loc *locale, t time.Time, short bool) string {
+			wd := loc.weekdays[t.Weekday()]
+			mo := loc.months[t.Month()-1]
+			if short {
+				return fmt.Sprintf("%s %d %s", wd[:3], t.Day(), mo[:3])
+			}
+			return fmt.Sprintf("%s %d %s %d", wd, t.Day(), mo, t.Year())
+		},
+		hourMin: "%d h %d min",
+	},
+	"fr": {
+		Name: "fr",
+		Date: "02/01/2006",
+		Time: "15h04",
+		weekdays: [7]string{
+			"dimanche", "lundi", "mardi", "mercredi",
+			"jeudi", "vendredi", "samedi",
+		},
+		months: [12]string{
+			"janvier", "février", "mars", "avril", "mai", "juin",
+			"juillet", "août", "septembre", "octobre", "novembre", "décembre",
+		},
+		dayFmt: func(loc *locale, t time.Time, short bool) string {
+			wd := loc.weekdays[t.Weekday()]
+			if short {
+				return fmt.Sprintf("%s. %d/%02d", wd[:3], t.Day(), t.Month())
+			}
+			return fmt.Sprintf("%s %d %s %d", wd, t.Day(), loc.months[t.Month()-1], t.Year())
+		},
+		hourMin: "%d h %02d",
+		strings: map[string]string{
+			"Web Display":   "Affichage",
+			"Programme":     "Programme",
+			"Now":           "Maintenant",
+			"delayed":       "retard",
+			"%d min left":   "encore %d min",
+			"offline since": "hors ligne depuis",
+		},
+	},
+}
+
+// localeNames returns the sorted names of the supported locales.
+func localeNames() []string {
+	var o []string
+	for name := range locales {
+		o = append(o, name)
+	}
+	sort.Strings(o)
+	return o
+}
+
+// tr translates a UI string.
+// Untranslated strings are returned as is.
+func (loc *locale) tr(s string) string {
+	if v, ok := loc.strings[s]; ok {
+		return v
+	}
+	return s
+}
+
+// formatDay returns the name of the day t, as in "Monday 26 September 2016".
+func (loc *locale) formatDay(t time.Time) string {
+	return loc.dayFmt(loc, t, false)
+}
+
+// formatShortDay returns the abbreviated name of the day t, as in "Mon 26 Sep".
+func (loc *locale) formatShortDay(t time.Time) string {
+	return loc.dayFmt(loc, t, true)
+}
+
+// formatDuration returns a human-friendly representation of d, as in "30 min"
+// or "1 h 30 min".
+func (loc *locale) formatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	d = d.Round(time.Minute)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%s%d min", sign, m)
+	case m == 0:
+		return fmt.Sprintf("%s%d h", sign, h)
+	}
+	return sign + fmt.Sprintf(loc.hourMin, h, m)
+}
+
+// link returns the given path with the locale added to its query.
+func (loc *locale) link(path string) string {
+	return path + "?" + url.Values{"lang": {loc.Name}}.Encode()
+}
+
+// funcs returns the template functions displaying values in this locale.
+func (loc *locale) funcs() template.FuncMap {
+	return template.FuncMap{
+		"lang":        func() string { return loc.Name },
+		"tr":          loc.tr,
+		"link":        loc.link,
+		"fmtDate":     func(t time.Time) string { return t.Format(loc.Date) },
+		"fmtTime":     func(t time.Time) string { return t.Format(loc.Time) },
+		"fmtDay":      loc.formatDay,
+		"fmtShortDay": loc.formatShortDay,
+		"fmtDuration": loc.formatDuration,
+		"displayP":    displayPresenters,
+	}
+}
+
+// locale returns the locale requested by the "lang" parameter of the request,
+// or the locale of the profile of the display, or the default locale of the
+// server.
+func (srv *server) locale(r *http.Request) *locale {
+	if loc, ok := locales[r.URL.Query().Get("lang")]; ok {
+		return loc
+	}
+	if loc, ok := locales[srv.profile(r.URL.Query().Get("display")).Lang]; ok {
+		return loc
+	}
+	srv.tmu.RLock()
+	defer srv.tmu.RUnlock()
+	return locales[srv.lang]
+}
@@ -0,0 +1,118 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// newLogger returns a logger writing to w in the given format (text or
+// json), discarding the records below the given level (debug, info, warn
+// or error).
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// logRequests logs the requests served by h, once served.
+// Successful requests are logged at the debug level, so the displays polling
+// the server do not flood the log.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+
+		lvl := slog.LevelDebug
+		switch {
+		case rw.status >= 500:
+			lvl = slog.LevelError
+		case rw.status >= 400:
+			lvl = slog.LevelWarn
+		}
+		slog.Log(r.Context(), lvl, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.status,
+			"size", rw.size,
+			"duration", time.Since(start),
+			"addr", r.RemoteAddr,
+		)
+	})
+}
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.size += n
+	return n, err
+}
+
+// Flush implements http.Flusher, for Server-Sent Events.
+func (rw *responseRecorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, for websockets.
+func (rw *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection cannot be hijacked")
+	}
+	if rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the underlying response writer, for
+// http.ResponseController.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,142 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCommands is the maximum number of display commands waiting to be sent
+// to a client.
+const maxCommands = 16
+
+// mailbox holds the messages waiting to be sent to a client.
+//
+// Messages are never queued behind a slow client: a new agenda replaces the
+// agenda not sent yet, and so does a new clock sync.
+// Display commands are queued, up to maxCommands.
+// Pushing a message to a mailbox never blocks.
+type mailbox struct {
+	mu      sync.Mutex
+	clock   []byte    // pending clock sync
+	agenda  []byte    // pending agenda
+	cmds    [][]byte  // pending display commands
+	since   time.Time // time at which the oldest pending message was pushed
+	dropped uint64    // number of messages replaced before being sent
+	closed  bool
+
+	ready chan struct{} // signaled when a message is pushed or the mailbox closed
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{ready: make(chan struct{}, 1)}
+}
+
+// setSync sets the pending clock sync, and returns whether a clock sync not
+// sent yet was dropped.
+func (m *mailbox) setSync(data []byte) bool {
+	return m.push(func() bool {
+		dropped := m.clock != nil
+		m.clock = data
+		return dropped
+	})
+}
+
+// setAgenda sets the pending agenda, and returns whether an agenda not sent
+// yet was dropped.
+func (m *mailbox) setAgenda(data []byte) bool {
+	return m.push(func() bool {
+		dropped := m.agenda != nil
+		m.agenda = data
+		return dropped
+	})
+}
+
+// addCommand queues a display command, and returns whether the oldest
+// command had to be dropped to make room for it.
+func (m *mailbox) addCommand(data []byte) bool {
+	return m.push(func() bool {
+		dropped := len(m.cmds) >= maxCommands
+		if dropped {
+			m.cmds = m.cmds[1:]
+		}
+		m.cmds = append(m.cmds, data)
+		return dropped
+	})
+}
+
+func (m *mailbox) push(set func() bool) bool {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return false
+	}
+	if m.since.IsZero() {
+		m.since = time.Now()
+	}
+	dropped := set()
+	if dropped {
+		m.dropped++
+	}
+	m.mu.Unlock()
+
+	m.signal()
+	return dropped
+}
+
+func (m *mailbox) signal() {
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next pending message, if any, and whether the mailbox is
+// still open.
+// Pending messages are still returned once the mailbox is closed.
+func (m *mailbox) pop() ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var data []byte
+	switch {
+	case m.clock != nil:
+		data, m.clock = m.clock, nil
+	case m.agenda != nil:
+		data, m.agenda = m.agenda, nil
+	case len(m.cmds) > 0:
+		data, m.cmds = m.cmds[0], m.cmds[1:]
+	}
+	if m.clock == nil && m.agenda == nil && len(m.cmds) == 0 {
+		m.since = time.Time{}
+	}
+	return data, !m.closed
+}
+
+// stalled returns for how long the oldest pending message has been waiting.
+func (m *mailbox) stalled(now time.Time) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.since.IsZero() {
+		return 0
+	}
+	return now.Sub(m.since)
+}
+
+// drops returns the number of messages replaced before being sent.
+func (m *mailbox) drops() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dropped
+}
+
+// close closes the mailbox. Closing a closed mailbox has no effect.
+func (m *mailbox) close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.signal()
+}
@@ -0,0 +1,133 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMailboxReplace(t *testing.T) {
+	box := newMailbox()
+	if box.setAgenda([]byte("agenda-1")) {
+		t.Fatalf("first agenda dropped")
+	}
+	if !box.setAgenda([]byte("agenda-2")) {
+		t.Fatalf("pending agenda not dropped")
+	}
+	if box.setSync([]byte("sync-1")) {
+		t.Fatalf("first sync dropped")
+	}
+	if !box.setSync([]byte("sync-2")) {
+		t.Fatalf("pending sync not dropped")
+	}
+	if got, want := box.drops(), uint64(2); got != want {
+		t.Fatalf("invalid number of drops: got=%d, want=%d", got, want)
+	}
+
+	// clock syncs are sent first, and only the latest messages are sent.
+	for _, want := range []string{"sync-2", "agenda-2"} {
+		data, ok := box.pop()
+		if !ok || string(data) != want {
+			t.Fatalf("invalid message: got=%q (open=%v), want=%q", data, ok, want)
+		}
+	}
+	if data, ok := box.pop(); data != nil || !ok {
+		t.Fatalf("invalid empty mailbox: got=%q (open=%v)", data, ok)
+	}
+
+	// once sent, messages are not counted as drops.
+	if box.setAgenda([]byte("agenda-3")) {
+		t.Fatalf("agenda dropped after being sent")
+	}
+	if got, want := box.drops(), uint64(2); got != want {
+		t.Fatalf("invalid number of drops: got=%d, want=%d", got, want)
+	}
+}
+
+func TestMailboxCommands(t *testing.T) {
+	box := newMailbox()
+	for i := 0; i < maxCommands; i++ {
+		if box.addCommand([]byte(fmt.Sprintf("cmd-%d", i))) {
+			t.Fatalf("command %d dropped", i)
+		}
+	}
+	if !box.addCommand([]byte("cmd-last")) {
+		t.Fatalf("oldest command not dropped")
+	}
+	if got, want := box.drops(), uint64(1); got != want {
+		t.Fatalf("invalid number of drops: got=%d, want=%d", got, want)
+	}
+
+	// commands are sent in order, the oldest one being dropped.
+	for i := 1; i <= maxCommands; i++ {
+		want := fmt.Sprintf("cmd-%d", i)
+		if i == maxCommands {
+			want = "cmd-last"
+		}
+		data, _ := box.pop()
+		if string(data) != want {
+			t.Fatalf("invalid command: got=%q, want=%q", data, want)
+		}
+	}
+	if data, _ := box.pop(); data != nil {
+		t.Fatalf("unexpected command %q", data)
+	}
+}
+
+func TestMailboxClose(t *testing.T) {
+	box := newMailbox()
+	box.setAgenda([]byte("agenda"))
+	box.addCommand([]byte("cmd"))
+	box.close()
+	box.close()
+
+	// messages pushed once closed are ignored.
+	if box.setSync([]byte("sync")) {
+		t.Fatalf("sync dropped by a closed mailbox")
+	}
+
+	// pending messages are still sent once closed.
+	for _, want := range []string{"agenda", "cmd"} {
+		data, ok := box.pop()
+		if string(data) != want || ok {
+			t.Fatalf("invalid message: got=%q (open=%v), want=%q", data, ok, want)
+		}
+	}
+	if data, ok := box.pop(); data != nil || ok {
+		t.Fatalf("invalid closed mailbox: got=%q (open=%v)", data, ok)
+	}
+
+	select {
+	case <-box.ready:
+	default:
+		t.Fatalf("closed mailbox not signaled")
+	}
+}
+
+func TestMailboxStalled(t *testing.T) {
+	box := newMailbox()
+	now := time.Now()
+	if got := box.stalled(now.Add(time.Hour)); got != 0 {
+		t.Fatalf("empty mailbox stalled for %v", got)
+	}
+
+	box.setAgenda([]byte("agenda"))
+	box.addCommand([]byte("cmd"))
+	if got := box.stalled(time.Now().Add(time.Hour)); got < time.Hour {
+		t.Fatalf("invalid stall: got=%v, want>=%v", got, time.Hour)
+	}
+
+	// the mailbox is stalled until all its messages are taken.
+	box.pop()
+	if got := box.stalled(time.Now().Add(time.Hour)); got < time.Hour {
+		t.Fatalf("invalid stall: got=%v, want>=%v", got, time.Hour)
+	}
+	box.pop()
+	if got := box.stalled(time.Now().Add(time.Hour)); got != 0 {
+		t.Fatalf("drained mailbox stalled for %v", got)
+	}
+}
@@ -6,221 +6,705 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
-	"text/template"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/clr-info/ji-web-display/indico"
 	"golang.org/x/net/websocket"
 )
 
-var (
-	// FIXME(sbinet): remove
-	devTest = flag.Bool("dev-test", false, "enable test development mode")
+const (
+	heartbeat    = 10 * time.Second // period of the heartbeats sent to the displays
+	shutdownWait = 10 * time.Second // maximum duration of a graceful shutdown
+	writeWait    = 10 * time.Second // maximum duration of a write to a display
+	deadTimeout  = 3 * heartbeat    // duration after which a silent display is disconnected
+
+	closeNormal    = 1000 // websocket close status of a normal closure
+	closeGoingAway = 1001 // websocket close status of a server shutting down
 )
 
 func main() {
 
-	log.SetFlags(0)
-	log.SetPrefix("ji-web-display: ")
-
-	var (
-		addr      = flag.String("addr", ":80", "[hostname|ip]:port for web server")
-		evtid     = flag.Int("evtid", 12779, "event id")
-		nowLayout = "2006-01-02 15:04:05"
-		snow      = flag.String("now", "", "agenda time. format="+nowLayout)
-		sloc      = flag.String("loc", "Europe/Paris", "agenda time location")
-	)
+	// the flags are parsed again by loadConfig, on top of the configuration
+	// file: they are only defined here for the usage message and -config.
+	defaults := defaultConfig()
+	defaults.flags(flag.CommandLine)
+	fname := flag.String("config", os.Getenv(envName("config")), "JSON configuration file")
 
 	flag.Parse()
 
-	var now time.Time
-	switch *snow {
-	case "":
-		now = time.Now()
-	default:
-		loc, err := time.LoadLocation(*sloc)
-		if err != nil {
-			log.Fatal(err)
-		}
-		now, err = time.ParseInLocation(nowLayout, *snow, loc)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
+	logs := newLogTail(200)
+	logw := io.MultiWriter(os.Stderr, logs)
 
-	host, port, err := net.SplitHostPort(*addr)
+	cfg, err := loadConfig(*fname, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ji-web-display: invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+	logger, err := newLogger(logw, cfg.Log.Format, cfg.Log.Level)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "ji-web-display: %v\n", err)
+		os.Exit(2)
 	}
+	slog.SetDefault(logger)
 
-	if host == "" {
-		host = getHostIP()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the configuration is valid: the times and locations below parse.
+	loc, _ := time.LoadLocation(cfg.Loc)
+	var now, start, end time.Time
+	for _, v := range []struct {
+		t   *time.Time
+		val string
+	}{
+		{&now, cfg.Now},
+		{&start, cfg.Sim.Start},
+		{&end, cfg.Sim.End},
+	} {
+		if v.val != "" {
+			*v.t, _ = time.ParseInLocation(nowLayout, v.val, loc)
+		}
 	}
+	base, _ := url.Parse(cfg.BaseURL)
 
-	var tbl *indico.TimeTable
+	var (
+		tbl     *indico.TimeTable
+		source  = "indico"
+		fetched time.Duration // duration of the fetch of the timetable
+	)
 
-	_, err = net.LookupIP("indico.in2p3.fr")
+	_, err = net.LookupIP(cfg.Indico)
 	if err != nil {
-		log.Printf("error looking up 'indico.in2p3.fr': %v\n", err)
-		log.Printf("loading cached table...\n")
-		tbl, err = loadCachedTable(*evtid)
+		slog.Warn("could not look up indico, loading cached table", "host", cfg.Indico, "error", err)
+		source = "embedded"
+		tbl, err = loadCachedTable(cfg.Event)
 		if err != nil {
-			log.Fatal(err)
+			fatal("could not load cached table", "event", cfg.Event, "error", err)
 		}
 	} else {
-		tbl, err = indico.FetchTimeTable("indico.in2p3.fr", *evtid)
+		start := time.Now()
+		tbl, err = indico.FetchTimeTable(cfg.Indico, cfg.Event)
+		fetched = time.Since(start)
 		if err != nil {
-			log.Fatal(err)
+			fatal("could not fetch timetable", "event", cfg.Event, "duration", fetched, "error", err)
 		}
+		slog.Info("timetable fetched", "event", cfg.Event, "duration", fetched)
 	}
 	sortTimeTable(tbl)
 
-	srv := newServer(host+":"+port, tbl, now)
-	mux := http.NewServeMux()
-	mux.Handle("/", srv)
-	mux.Handle("/data", websocket.Handler(srv.dataHandler))
-	mux.HandleFunc("/refresh-time", srv.refreshTime)
-	mux.HandleFunc("/refresh-timetable", srv.refreshTableHandler)
-	mux.HandleFunc("/logo", srv.logoHandler)
-
-	if !*devTest {
-		go refreshTime(srv.Addr)
+	var clock Clock = realClock{}
+	switch {
+	case cfg.Sim.Enabled:
+		sc, err := newSimClock(clock, tbl, start, end, cfg.Sim.Speed)
+		if err != nil {
+			fatal("invalid simulation", "error", err)
+		}
+		if !now.IsZero() {
+			sc = sc.seek(now)
+		}
+		clock = sc
+		slog.Info("simulation mode", "start", clock.Now(), "speed", cfg.Sim.Speed)
+	case !now.IsZero():
+		clock = newOffsetClock(clock, now)
 	}
 
-	err = http.ListenAndServe(srv.Addr, mux)
+	srv := newServer(cfg.Addr, tbl, clock)
+	srv.base = strings.TrimSuffix(base.String(), "/") + "/"
+	srv.loc = loc
+	srv.logs = logs
+	srv.source = source
+	if source == "indico" {
+		srv.metrics.fetched(fetched, nil)
+	}
+	srv.tmplDir = cfg.Templates
+	err = srv.configure(&cfg)
 	if err != nil {
-		log.Fatal(err)
+		fatal("invalid configuration", "error", err)
+	}
+	if cfg.Templates != "" && cfg.ReloadTemplates {
+		go srv.watchTemplates(ctx, cfg.Templates)
+	}
+	srv.start(ctx)
+
+	hsrv := &http.Server{
+		Addr:    srv.Addr,
+		Handler: srv.handler(base.Path),
+	}
+
+	if cfg.TLS.Cert != "" || cfg.TLS.SelfSigned {
+		cert, err := loadCertificate(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.SelfSigned)
+		if err != nil {
+			fatal("could not load TLS certificate", "error", err)
+		}
+		hsrv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+
+	var rsrv *http.Server
+	if cfg.RedirectAddr != "" {
+		rsrv = &http.Server{
+			Addr:    cfg.RedirectAddr,
+			Handler: redirectHandler(srv.Addr),
+		}
+		go func() {
+			err := rsrv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				fatal("could not start redirect server", "addr", cfg.RedirectAddr, "error", err)
+			}
+		}()
+	}
+
+	// the configuration is reloaded on SIGHUP, keeping the displays
+	// connected.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+			slog.Info("reloading configuration...", "file", *fname)
+			next, err := loadConfig(*fname, os.Args[1:])
+			if err != nil {
+				slog.Error("invalid configuration", "file", *fname, "error", err)
+				continue
+			}
+			logger, err := newLogger(logw, next.Log.Format, next.Log.Level)
+			if err != nil {
+				slog.Error("invalid configuration", "file", *fname, "error", err)
+				continue
+			}
+			applied, err := srv.reload(&cfg, &next)
+			if applied {
+				cfg = next
+				slog.SetDefault(logger)
+			}
+			if err != nil {
+				slog.Error("error reloading configuration", "file", *fname, "error", err)
+				continue
+			}
+			slog.Info("reloading configuration... [done]", "file", *fname)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		stop() // a second signal kills the server right away.
+
+		slog.Info("shutting down...")
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownWait)
+		defer cancel()
+		if rsrv != nil {
+			rsrv.Shutdown(sctx)
+		}
+		err := hsrv.Shutdown(sctx)
+		if err != nil {
+			slog.Error("error shutting down", "error", err)
+			return
+		}
+		err = srv.wait(sctx)
+		if err != nil {
+			slog.Error("error shutting down", "error", err)
+			return
+		}
+		slog.Info("shutting down... [done]")
+	}()
+
+	switch hsrv.TLSConfig {
+	case nil:
+		err = hsrv.ListenAndServe()
+	default:
+		err = hsrv.ListenAndServeTLS("", "")
+	}
+	if err != http.ErrServerClosed {
+		fatal("could not start server", "addr", srv.Addr, "error", err)
 	}
+	<-done
 }
 
 type server struct {
 	Addr string
-	tmpl *template.Template
+	base string // external base URL of the server, with a trailing slash
 
-	reg registry
+	tmu     sync.RWMutex
+	tmplDir string                        // directory of the templates overriding the built-in ones
+	tmpls   map[string]*template.Template // templates, per locale
+	themes  map[string]string             // stylesheets, per theme
+	css     map[string]string             // stylesheets of the themes of the configuration
+	lang    string                        // default locale
+	theme   string                        // default theme
 
-	timec  chan time.Time
-	now    time.Time
-	datac  chan []byte
+	reg     registry
+	wg      sync.WaitGroup // crawler, broadcaster and clients
+	dropped atomic.Uint64  // messages replaced before being sent to slow clients
+	evicted atomic.Uint64  // clients evicted for not taking their messages
+	metrics *metrics
+	ticked  atomic.Int64 // time of the last tick of the crawler, in nanoseconds since the Unix epoch
+
+	logs *logTail // last lines of the log, for the admin console
+	loc  *time.Location
+	wall Clock // real time, for the announcements and the clock syncs. set before start.
+
+	datac  chan frame
+	kickc  chan struct{}
 	mu     sync.RWMutex
+	clock  Clock // time of the agenda
 	ttable *indico.TimeTable
+	delays delays
+	agenda Agenda // last rendered agenda
+
+	source string    // origin of the timetable
+	loaded time.Time // time at which the timetable was loaded
+
+	auth     adminAuth          // protection of the admin endpoints
+	indico   string             // host of the Indico server
+	tick     time.Duration      // period of the renderings of the agenda
+	trim     trimSizes          // how much of the agenda is displayed
+	profiles map[string]profile // settings of the displays, per name
+
+	announces []Announcement
+	nextID    int // ID of the last created announcement
 }
 
-func newServer(addr string, timeTable *indico.TimeTable, now time.Time) *server {
+func newServer(addr string, timeTable *indico.TimeTable, clock Clock) *server {
 	srv := &server{
-		Addr: addr,
-		tmpl: template.Must(template.Must(template.New("ji-web").Funcs(template.FuncMap{
-			"displayP": displayPresenters,
-		}).Parse(mainPage)).Parse(agendaTmpl)),
-		reg:    newRegistry(),
-		timec:  make(chan time.Time),
-		now:    now,
-		datac:  make(chan []byte),
-		ttable: timeTable,
-	}
-	go srv.crawler()
-	go srv.run()
+		Addr:    addr,
+		base:    "/",
+		lang:    "en",
+		theme:   "default",
+		reg:     newRegistry(),
+		metrics: newMetrics(),
+		loc:     time.Local,
+		wall:    realClock{},
+		datac:   make(chan frame),
+		kickc:   make(chan struct{}, 1),
+		clock:   clock,
+		ttable:  timeTable,
+		delays:  newDelays(),
+		source:  "indico",
+		loaded:  time.Now(),
+		indico:  "indico.in2p3.fr",
+		tick:    1 * time.Second,
+		trim:    defaultTrim,
+	}
+	err := srv.loadTemplates("")
+	if err != nil {
+		panic(err)
+	}
 	return srv
 }
 
+// start starts rendering the agenda and broadcasting it to the displays,
+// until ctx is done.
+// The server may then be reconfigured with configure or reload only: the
+// fields set directly (e.g. base, loc, wall or logs) must not change.
+func (srv *server) start(ctx context.Context) {
+	srv.ticked.Store(time.Now().UnixNano())
+	srv.wg.Add(2)
+	go func() {
+		defer srv.wg.Done()
+		srv.crawler(ctx)
+	}()
+	go func() {
+		defer srv.wg.Done()
+		srv.run(ctx)
+	}()
+}
+
+// wait waits for the crawler, the broadcaster and the clients to stop, or
+// for ctx to be done.
+func (srv *server) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (srv *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	srv.tmpl.Execute(w, srv)
+	err := srv.template(r).ExecuteTemplate(w, "page", srv.clockSync())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// template returns the templates for the locale requested by r.
+func (srv *server) template(r *http.Request) *template.Template {
+	name := srv.locale(r).Name
+	srv.tmu.RLock()
+	defer srv.tmu.RUnlock()
+	return srv.tmpls[name]
 }
 
-func (srv *server) run() {
+func (srv *server) run(ctx context.Context) {
+	last := make(frame) // last frame entries broadcast
 	for {
 		select {
+		case <-ctx.Done():
+			// clients send the frames they already received, and are
+			// then disconnected.
+			close(srv.reg.done)
+			for c := range srv.reg.clients {
+				c.box.close()
+				delete(srv.reg.clients, c)
+			}
+			return
+
 		case c := <-srv.reg.register:
+			srv.wg.Add(1)
 			srv.reg.clients[c] = true
-			log.Printf("new client: %v\n", c)
+			if data := last[c.frame()]; data != nil {
+				c.box.setAgenda(data)
+			}
+			if p := srv.profile(c.name); !c.targeted && (p.Room != "" || p.Screen != "") {
+				c.box.addCommand(p.target())
+			}
+			slog.Debug("new client", "addr", c.addr, "display", c.name, "transport", c.via)
 
 		case c := <-srv.reg.unregister:
 			if _, ok := srv.reg.clients[c]; ok {
 				delete(srv.reg.clients, c)
-				close(c.datac)
-				log.Printf("client disconnected [%v]\n", c.ws.LocalAddr())
+				c.box.close()
+				slog.Debug("client disconnected", "addr", c.addr, "display", c.name)
 			}
 
 		case data := <-srv.datac:
+			srv.metrics.broadcasts.Add(1)
+			for k, v := range data {
+				if k != frameSync {
+					last[k] = v
+				}
+			}
+			now := time.Now()
 			for c := range srv.reg.clients {
-				select {
-				case c.datac <- data:
-				default:
-					close(c.datac)
+				if d := c.box.stalled(now); d > deadTimeout {
+					// the client did not take any message for too long.
 					delete(srv.reg.clients, c)
+					c.box.close()
+					srv.evicted.Add(1)
+					slog.Warn("evicting stalled client", "addr", c.addr, "display", c.name, "stalled", d)
+					continue
+				}
+				if msg := data[frameSync]; msg != nil && c.box.setSync(msg) {
+					srv.dropped.Add(1)
+				}
+				if msg := data[c.frame()]; msg != nil && c.box.setAgenda(msg) {
+					srv.dropped.Add(1)
+				}
+			}
+
+		case req := <-srv.reg.list:
+			o := make([]clientInfo, 0, len(srv.reg.clients))
+			for c := range srv.reg.clients {
+				o = append(o, clientInfo{
+					Name:      c.name,
+					Addr:      c.addr,
+					Agent:     c.agent,
+					Transport: c.via,
+					Lang:      c.lang,
+					JSON:      c.json,
+					Since:     c.since,
+					LastAck:   c.lastAck(),
+					Dropped:   c.box.drops(),
+				})
+			}
+			req <- o
+
+		case cmd := <-srv.reg.command:
+			n := 0
+			for c := range srv.reg.clients {
+				if c.name != cmd.name {
+					continue
+				}
+				if c.box.addCommand(cmd.data) {
+					srv.dropped.Add(1)
 				}
+				n++
 			}
+			cmd.n <- n
 		}
 	}
 }
 
-func (srv *server) crawler() {
-	beat := 1 * time.Second
-	ticker := time.NewTicker(beat)
+func (srv *server) crawler(ctx context.Context) {
+	srv.mu.RLock()
+	tick := srv.tick
+	srv.mu.RUnlock()
+	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 
-	now := srv.now
-
+	var (
+		last   string                      // last rendering error
+		sums   = make(map[string]checksum) // checksums of the frames broadcast
+		synced clockSync                   // last clock sync broadcast
+		syncAt time.Time                   // time of the last clock sync
+	)
 	for {
 		select {
-		case srv.now = <-srv.timec:
-			now = srv.now
+		case <-ctx.Done():
+			return
+		case <-srv.kickc:
 		case <-ticker.C:
-			buf := new(bytes.Buffer)
-			if *devTest {
-				h := now.Hour()
-				switch {
-				case h >= 0 && h < 8:
-					beat = 1 * time.Hour
-				case h >= 8 && h <= 18:
-					beat = 3 * time.Minute
-				case h > 18 && h <= 22:
-					beat = 30 * time.Minute
-				case h > 22:
-					beat = 1 * time.Hour
-				}
+		}
+		srv.ticked.Store(time.Now().UnixNano())
+		srv.mu.RLock()
+		if srv.tick != tick {
+			tick = srv.tick
+			ticker.Reset(tick)
+		}
+		srv.mu.RUnlock()
+		start := time.Now()
+		out, outSums, err := srv.render(srv.Now())
+		srv.metrics.render.observe(time.Since(start).Seconds())
+		if err != nil {
+			// displays keep the last agenda until the error is fixed.
+			if err.Error() != last {
+				slog.Error("error rendering agenda", "error", err)
 			}
-			now = now.Add(beat)
-			srv.mu.RLock()
-			data := newAgenda(now, srv.ttable)
-			srv.mu.RUnlock()
-			err := srv.tmpl.ExecuteTemplate(buf, "agenda", data)
+			last = err.Error()
+			continue
+		}
+		last = ""
+
+		// only the frames which changed are broadcast: the displays run
+		// their own clock in between.
+		for k, sum := range outSums {
+			if sums[k] == sum {
+				delete(out, k)
+				continue
+			}
+			sums[k] = sum
+		}
+
+		// the clocks of the displays are synchronized again whenever the
+		// time of the agenda jumps.
+		sync := srv.clockSync()
+		if now := srv.wall.Now(); sync.drifted(synced, now.Sub(syncAt)) {
+			buf, err := sync.encode()
 			if err != nil {
-				log.Fatal(err)
-			}
-			srv.datac <- buf.Bytes()
-			if *devTest {
-				layout := "2006-01-02 15:04 -0700"
-				end, _ := time.Parse(layout, "2016-09-29 12:12 +0200")
-				start, _ := time.Parse(layout, "2016-09-26 14:45 +0200")
-				if now.After(end) || now.Before(start) {
-					now = start.Add(10 * time.Second)
-				}
+				slog.Error("could not synchronize displays", "error", err)
+			} else {
+				out[frameSync] = buf
+				synced = sync
+				syncAt = now
+			}
+		}
+
+		if len(out) == 0 {
+			continue
+		}
+		select {
+		case srv.datac <- out:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Now returns the time of the agenda.
+func (srv *server) Now() time.Time {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.clock.Now()
+}
+
+// setClock sets the clock telling the time of the agenda.
+func (srv *server) setClock(clock Clock) {
+	srv.mu.Lock()
+	srv.clock = clock
+	srv.mu.Unlock()
+	srv.kick()
+}
+
+// funcs returns the template functions depending on the server configuration.
+func (srv *server) funcs() template.FuncMap {
+	return template.FuncMap{
+		"base": func() string { return srv.base },
+	}
+}
+
+// handler returns the handler serving the displays and the admin endpoints,
+// mounted under the given path prefix.
+func (srv *server) handler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", srv)
+	mux.Handle("/data", websocket.Server{
+		Handler:   srv.dataHandler,
+		Handshake: srv.handshake,
+	})
+	mux.HandleFunc("/events", srv.eventsHandler)
+	mux.HandleFunc("/poll", srv.pollHandler)
+	mux.HandleFunc("/api/agenda", srv.apiAgendaHandler)
+	mux.HandleFunc("/metrics", srv.admin(srv.metricsHandler, http.MethodGet))
+	mux.HandleFunc("/healthz", srv.healthzHandler)
+	mux.HandleFunc("/readyz", srv.readyzHandler)
+	mux.HandleFunc("/admin", srv.adminLogin(srv.admin(srv.adminHandler, http.MethodGet)))
+	mux.HandleFunc("/displays", srv.admin(srv.displaysHandler, http.MethodGet))
+	mux.HandleFunc("/display", srv.admin(srv.displayHandler, http.MethodPost))
+	mux.HandleFunc("/refresh-time", srv.admin(srv.refreshTime, http.MethodPost))
+	mux.HandleFunc("/simulation", srv.admin(srv.simulationHandler, http.MethodPost))
+	mux.HandleFunc("/refresh-timetable", srv.admin(srv.refreshTableHandler, http.MethodPost))
+	mux.HandleFunc("/delay", srv.admin(srv.delayHandler, http.MethodPost))
+	mux.HandleFunc("/announce", srv.admin(srv.announceHandler, http.MethodPost))
+	mux.HandleFunc("/logo", srv.logoHandler)
+	mux.HandleFunc("/theme.css", srv.themeHandler)
+	mux.HandleFunc("/programme", srv.programmeHandler)
+	mux.HandleFunc("/day/", srv.dayHandler)
+	return logRequests(stripPrefix(prefix, mux))
+}
+
+// stripPrefix strips the given path prefix from the requests, if present, so
+// the server can be mounted under that prefix behind a reverse proxy.
+func stripPrefix(prefix string, h http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+			if r2.URL.Path == "" {
+				r2.URL.Path = "/"
 			}
+			r2.URL.RawPath = ""
+			r = r2
 		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// frame holds a rendered agenda, per locale, its JSON representation under
+// the frameJSON key, and a clock sync under the frameSync key.
+// Entries which did not change since the previous frame are omitted.
+type frame map[string][]byte
+
+const (
+	frameJSON = "json"
+	frameSync = "sync"
+)
+
+// checksum identifies the content of a frame entry.
+type checksum [sha256.Size]byte
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// render renders the agenda at the given time, for all clients.
+// render also returns the checksums of the frame entries, which do not
+// depend on the time at which the agenda was rendered.
+func (srv *server) render(now time.Time) (frame, map[string]checksum, error) {
+	srv.mu.Lock()
+	data := newAgenda(now, srv.ttable, srv.delays, srv.trim)
+	wall := srv.wall.Now()
+	srv.pruneAnnouncements(wall)
+	data.Announcements = srv.activeAnnouncements(wall)
+	srv.agenda = data
+	srv.mu.Unlock()
+	srv.tmu.RLock()
+	tmpls := srv.tmpls
+	srv.tmu.RUnlock()
+	out := make(frame, len(tmpls)+1)
+	sums := make(map[string]checksum, len(tmpls)+1)
+	for name, tmpl := range tmpls {
+		buf := new(bytes.Buffer)
+		err := tmpl.ExecuteTemplate(buf, "agenda", data)
+		if err != nil {
+			return nil, nil, err
+		}
+		out[name] = buf.Bytes()
+		sums[name] = sha256.Sum256(buf.Bytes())
+	}
+	buf, err := json.Marshal(newAPIAgenda(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	out[frameJSON] = buf
+	buf, err = json.Marshal(stableAPIAgenda(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	sums[frameJSON] = sha256.Sum256(buf)
+	return out, sums, nil
+}
+
+// kick requests the crawler to render and broadcast the agenda right away.
+func (srv *server) kick() {
+	select {
+	case srv.kickc <- struct{}{}:
+	default:
 	}
 }
+
 func (srv *server) dataHandler(ws *websocket.Conn) {
+	r := ws.Request()
 	c := &client{
 		srv:   srv,
 		reg:   &srv.reg,
-		datac: make(chan []byte, 256),
+		box:   newMailbox(),
 		ws:    ws,
+		addr:  r.RemoteAddr,
+		name:  r.FormValue("display"),
+		agent: r.UserAgent(),
+		lang:  srv.locale(r).Name,
+		since: time.Now(),
+		via:   "websocket",
+
+		targeted: r.FormValue("room") != "" || r.FormValue("screen") != "",
+	}
+	for _, p := range ws.Config().Protocol {
+		c.json = p == protoJSON
+	}
+	if !c.reg.add(c) {
+		c.closeWS(closeGoingAway)
+		return
 	}
-	c.reg.register <- c
 	defer c.Release()
 
-	c.run()
+	go c.readAcks()
+
+	c.run(func(data []byte) error {
+		ws.SetWriteDeadline(time.Now().Add(writeWait))
+		return websocket.Message.Send(ws, string(data))
+	})
 }
 
 func (srv *server) logoHandler(w http.ResponseWriter, r *http.Request) {
@@ -228,91 +712,541 @@ func (srv *server) logoHandler(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, img)
 }
 
+// refreshTime resets the time of the agenda to the current time, or to the
+// time given by the "now" form value, from which the agenda then runs.
 func (srv *server) refreshTime(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "invalid http request", http.StatusBadRequest)
-		return
+	var clock Clock = realClock{}
+	if v := r.FormValue("now"); v != "" {
+		now, err := parseTime(v, srv.loc)
+		if err != nil {
+			http.Error(w, "invalid time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		clock = newOffsetClock(clock, now)
 	}
-	now := time.Now()
-	go func() {
-		log.Printf("refreshing server internal time...\n")
-		srv.timec <- now
-		log.Printf("refreshing server internal time... [done]\n")
-	}()
+	srv.setClock(clock)
+	now := clock.Now()
+	slog.Info("server internal time set", "now", now)
 	fmt.Fprintf(w, "time is now: %v\n", now)
 }
 
 func (srv *server) refreshTableHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "invalid http request", http.StatusBadRequest)
+	srv.mu.RLock()
+	id := srv.ttable.ID
+	srv.mu.RUnlock()
+
+	err := srv.refreshTable(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	fmt.Fprintf(w, "timetable-%d refreshed\n", id)
+}
+
+// refreshTable fetches the timetable of the given event from Indico, and
+// displays it.
+func (srv *server) refreshTable(id int) error {
+	// the timetable is fetched without holding the lock, so the displays
+	// keep being updated in the meantime.
+	slog.Info("refreshing timetable...", "event", id)
+	start := time.Now()
+	tbl, err := srv.fetchTimeTable(id)
+	if err != nil {
+		slog.Error("error fetching timetable", "event", id, "duration", time.Since(start), "error", err)
+		return err
+	}
+	sortTimeTable(tbl)
 
 	srv.mu.Lock()
-	defer srv.mu.Unlock()
+	srv.ttable = tbl
+	srv.source = "indico"
+	srv.loaded = time.Now()
+	if clock, ok := srv.clock.(*simClock); ok {
+		// the simulation runs over the days of the new timetable.
+		clock, err = clock.withTable(tbl)
+		if err != nil {
+			slog.Warn("could not update simulation to the new timetable", "event", id, "error", err)
+		} else {
+			srv.clock = clock
+		}
+	}
+	srv.mu.Unlock()
+	srv.kick()
 
-	id := srv.ttable.ID
-	log.Printf("refreshing timetable-%d...\n", id)
-	tbl, err := indico.FetchTimeTable("indico.in2p3.fr", srv.ttable.ID)
+	slog.Info("refreshing timetable... [done]", "event", id, "duration", time.Since(start))
+	return nil
+}
+
+// delayHandler declares a delay for a session or a room.
+// The delay is given by the "offset" form value (e.g. "+10m") and applies
+// to the session whose Indico ID is given by the "session" form value, or
+// to all the sessions held in the room given by the "room" form value.
+// A zero offset clears the delay.
+func (srv *server) delayHandler(w http.ResponseWriter, r *http.Request) {
+	offset, err := time.ParseDuration(r.FormValue("offset"))
 	if err != nil {
-		log.Printf("error fetching timetable-%d: %v\n", id, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "invalid offset: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	srv.ttable = tbl
-	sortTimeTable(srv.ttable)
-	log.Printf("refreshing timetable-%d... [done]\n", id)
-	fmt.Fprintf(w, "timetable-%d refreshed\n", id)
+
+	key, byRoom := r.FormValue("session"), false
+	if key == "" {
+		key, byRoom = r.FormValue("room"), true
+	}
+	if key == "" {
+		http.Error(w, "missing session or room", http.StatusBadRequest)
+		return
+	}
+
+	srv.mu.Lock()
+	target := srv.delays.Sessions
+	if byRoom {
+		target = srv.delays.Rooms
+	}
+	if offset == 0 {
+		delete(target, key)
+	} else {
+		target[key] = offset
+	}
+	srv.mu.Unlock()
+	srv.kick()
+
+	if offset == 0 {
+		slog.Info("delay cleared", "key", key)
+		fmt.Fprintf(w, "delay for %q cleared\n", key)
+		return
+	}
+	slog.Info("delay set", "key", key, "offset", offset)
+	fmt.Fprintf(w, "delay for %q set to %v\n", key, offset)
 }
 
 type client struct {
 	srv   *server
 	reg   *registry
-	ws    *websocket.Conn
-	datac chan []byte
+	ws    *websocket.Conn // nil for SSE and long-polling clients
+	addr  string          // remote address
+	name  string          // name or generated ID of the display
+	agent string          // user agent of the display
+	box   *mailbox        // messages waiting to be sent
+	lang  string          // locale of the client
+	json  bool            // whether the client requested the JSON representation
+	since time.Time       // connection time
+	via   string          // transport used by the client
+
+	targeted bool // whether the client gave its room or screen
+
+	wsClose sync.Once // closes the websocket connection
+
+	mu  sync.Mutex
+	ack time.Time // time of the last acknowledgement
 }
 
+// acked records an acknowledgement from the client.
+func (c *client) acked() {
+	c.mu.Lock()
+	c.ack = time.Now()
+	c.mu.Unlock()
+}
+
+// lastAck returns the time of the last acknowledgement from the client.
+func (c *client) lastAck() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ack
+}
+
+// frame returns the key of the frame entry sent to the client.
+func (c *client) frame() string {
+	if c.json {
+		return frameJSON
+	}
+	return c.lang
+}
+
+// Release unregisters the client and closes its connection.
+// When the server is shutting down, websocket clients are told so with a
+// "going away" close frame.
 func (c *client) Release() {
-	c.reg.unregister <- c
-	c.ws.Close()
+	select {
+	case c.reg.unregister <- c:
+	case <-c.reg.done:
+	}
+	if c.ws != nil {
+		status := closeNormal
+		select {
+		case <-c.reg.done:
+			status = closeGoingAway
+		default:
+		}
+		c.closeWS(status)
+	}
+	c.srv.wg.Done()
 	c.reg = nil
 	c.srv = nil
 }
 
-func (c *client) run() {
-	for data := range c.datac {
-		err := websocket.Message.Send(c.ws, string(data))
+// closeWS sends a close frame with the given status to a websocket client,
+// and closes the connection.
+// Only the first call has an effect, so a single close frame is sent.
+func (c *client) closeWS(status int) {
+	c.wsClose.Do(func() {
+		ws := c.ws
+		ws.SetWriteDeadline(time.Now().Add(writeWait))
+		ws.PayloadType = websocket.CloseFrame
+		ws.Write([]byte{byte(status >> 8), byte(status & 0xff)})
+		// ws.Close writes a close frame of its own before closing the
+		// connection: it is made to fail, so the status sent above is
+		// the only one.
+		ws.SetWriteDeadline(time.Unix(1, 0))
+		ws.Close()
+	})
+}
+
+// run sends the frames broadcast to the client until send fails or the
+// client is unregistered.
+// A clock sync is sent as a heartbeat when no frame was broadcast for a while.
+func (c *client) run(send func(data []byte) error) {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		data, ok := c.box.pop()
+		switch {
+		case data != nil:
+			ticker.Reset(heartbeat)
+		case !ok:
+			return
+		default:
+			select {
+			case <-c.box.ready:
+				continue
+			case <-ticker.C:
+				var err error
+				data, err = c.srv.clockSync().encode()
+				if err != nil {
+					slog.Error("could not send heartbeat", "addr", c.addr, "display", c.name, "error", err)
+					continue
+				}
+			}
+		}
+		err := send(data)
+		if err != nil {
+			slog.Debug("error sending data", "addr", c.addr, "display", c.name, "error", err)
+			return
+		}
+		c.srv.metrics.sent.Add(1)
+	}
+}
+
+// readAcks reads the acknowledgements sent back by a websocket display, and
+// closes the connection when none was received for deadTimeout.
+// Clients of the JSON subprotocol are not required to acknowledge anything:
+// their messages are only read to notice when they leave.
+func (c *client) readAcks() {
+	for {
+		if !c.json {
+			c.ws.SetReadDeadline(time.Now().Add(deadTimeout))
+		}
+		var msg string
+		err := websocket.Message.Receive(c.ws, &msg)
 		if err != nil {
-			log.Printf(
-				"error sending data to [%v]: %v\n",
-				c.ws.LocalAddr(),
-				err,
-			)
-			break
+			slog.Debug("closing connection", "addr", c.addr, "display", c.name, "error", err)
+			c.closeWS(closeNormal)
+			return
 		}
+		c.acked()
 	}
 }
 
 type registry struct {
+	done       chan struct{} // closed when the server shuts down
 	clients    map[*client]bool
 	register   chan *client
 	unregister chan *client
+	list       chan chan []clientInfo
+	command    chan command
+}
+
+// add registers a client, unless the server is shutting down.
+func (reg *registry) add(c *client) bool {
+	select {
+	case reg.register <- c:
+		return true
+	case <-reg.done:
+		return false
+	}
 }
 
 func newRegistry() registry {
 	return registry{
+		done:       make(chan struct{}),
 		clients:    make(map[*client]bool),
 		register:   make(chan *client),
 		unregister: make(chan *client),
+		list:       make(chan chan []clientInfo),
+		command:    make(chan command),
 	}
 }
 
-const mainPage = `<!DOCTYPE html>
-<html>
+const mainPage = `{{define "page"}}<!DOCTYPE html>
+<html lang="{{lang}}">
 	<head>
 		<meta name="viewport" content="width=device-width, minimum-scale=1.0, initial-scale=1.0, user-scalable=yes">
 		<meta charset="utf-8">
-		<title>JI-2016 Web Display</title>
+		<title>JI-2016 {{tr "Web Display"}}</title>
+		<base href="{{base}}">
+		{{template "style"}}
+		<script type="text/javascript">
+		var sock = null;
+
+		var params = new URLSearchParams(window.location.search);
+
+		// the display identifies itself with the "display" URL parameter, or
+		// with an ID generated once and kept in the local storage.
+		if (!params.get("display")) {
+			var id = null;
+			try {
+				id = window.localStorage.getItem("ji-display");
+				if (!id) {
+					id = "display-" + Math.random().toString(36).slice(2, 10);
+					window.localStorage.setItem("ji-display", id);
+				}
+			} catch (e) {
+			}
+			if (id) {
+				params.set("display", id);
+			}
+		}
+
+		// query returns the query string sent to the server.
+		function query() {
+			return "?" + params.toString();
+		};
+
+		var last = "";
+
+		function update(data) {
+			if (data.charAt(0) == "{") {
+				control(JSON.parse(data));
+				return;
+			}
+			last = data;
+			var doc = document.getElementById("agenda");
+			doc.innerHTML = data;
+			filterAnnouncements(doc);
+			tick();
+		};
+
+		// clock is the last clock sync received from the server, and the
+		// local time at which it was received.
+		var clock = null;
+
+		function sync(cmd) {
+			clock = cmd;
+			clock.at = Date.now();
+			tick();
+		};
+
+		// tick displays the time of the agenda, as predicted from the last
+		// clock sync.
+		function tick() {
+			var doc = document.getElementById("clock");
+			if (clock == null || doc == null) {
+				return;
+			}
+			var t = new Date(clock.time + (Date.now() - clock.at) * clock.speed + clock.offset * 1000);
+			doc.textContent = pad(t.getUTCHours()) + ":" + pad(t.getUTCMinutes()) + ":" + pad(t.getUTCSeconds());
+		};
+
+		// control applies a command sent by an admin to this display.
+		function control(cmd) {
+			switch (cmd.control) {
+			case "sync":
+				sync(cmd);
+				return;
+			case "reload":
+				window.location.reload();
+				return;
+			case "theme":
+				params.set("theme", cmd.theme);
+				document.getElementById("theme").href = "theme.css" + query();
+				break;
+			case "target":
+				var keys = ["room", "screen"];
+				for (var i = 0; i < keys.length; i++) {
+					if (cmd[keys[i]]) {
+						params.set(keys[i], cmd[keys[i]]);
+					} else {
+						params.delete(keys[i]);
+					}
+				}
+				if (last != "") {
+					update(last);
+				}
+				break;
+			default:
+				return;
+			}
+			window.history.replaceState(null, "", query());
+		};
+
+		// filterAnnouncements hides the announcements which do not target
+		// this screen, as given by the "screen" and "room" URL parameters.
+		function filterAnnouncements(doc) {
+			var anns = doc.querySelectorAll("[data-targets]");
+			for (var i = 0; i < anns.length; i++) {
+				var targets = anns[i].getAttribute("data-targets");
+				if (targets == "") {
+					continue;
+				}
+				targets = targets.split("|");
+				if (targets.indexOf(params.get("screen")) < 0 && targets.indexOf(params.get("room")) < 0) {
+					anns[i].style.display = "none";
+				}
+			}
+		};
+
+		// wsURL returns the websocket URL of the given endpoint, relative to
+		// the base URL of the document.
+		function wsURL(endpoint) {
+			var u = new URL(endpoint + query(), document.baseURI);
+			u.protocol = u.protocol == "https:" ? "wss:" : "ws:";
+			return u.href;
+		};
+
+		// transports lists the ways to receive the agenda, by order of preference.
+		// Each transport calls recv with the data it receives (empty for
+		// heartbeats) and closed once it is closed.
+		var transports = [
+			function(recv, closed) {
+				if (!window.WebSocket) {
+					closed();
+					return;
+				}
+				sock = new WebSocket(wsURL("data"));
+				sock.onmessage = function(event) {
+					recv(event.data);
+					sock.send("ack");
+				};
+				sock.onclose = function() {
+					closed();
+				};
+			},
+			function(recv, closed) {
+				if (!window.EventSource) {
+					closed();
+					return;
+				}
+				var src = new EventSource("events" + query());
+				src.onmessage = function(event) {
+					recv(event.data);
+				};
+				src.onerror = function() {
+					src.close();
+					closed();
+				};
+			},
+			function poll(recv, closed) {
+				var req = new XMLHttpRequest();
+				req.open("GET", "poll" + query());
+				if (poll.etag) {
+					req.setRequestHeader("If-None-Match", poll.etag);
+				}
+				req.onload = function() {
+					switch (req.status) {
+					case 200:
+						poll.etag = req.getResponseHeader("ETag") || poll.etag;
+						recv(req.responseText);
+						poll(recv, closed);
+						break;
+					case 204:
+						recv("");
+						poll(recv, closed);
+						break;
+					default:
+						closed();
+					}
+				};
+				req.onerror = function() {
+					closed();
+				};
+				req.send();
+			}
+		];
+
+		var worked = [];      // whether each transport ever delivered data
+		var backoff = 1000;   // delay before the next reconnection, in ms
+		var maxBackoff = 60000;
+		var staleAfter = 30000; // 3 server heartbeats, in ms
+		var lastData = null;  // time of the last data received
+		var offlineText = {{tr "offline since"}};
+
+		// connect receives the agenda with the i-th transport.
+		// Transports are reconnected with an exponential backoff when they are
+		// closed, or replaced by the next one if they never delivered any data.
+		function connect(i) {
+			var done = false;
+			transports[i](function(data) {
+				worked[i] = true;
+				backoff = 1000;
+				lastData = new Date();
+				setOffline(false);
+				if (data != "") {
+					update(data);
+				}
+			}, function() {
+				if (done) {
+					return;
+				}
+				done = true;
+				setOffline(true);
+				if (!worked[i] && i+1 < transports.length) {
+					connect(i+1);
+					return;
+				}
+				setTimeout(function() { connect(i); }, backoff);
+				backoff = Math.min(2*backoff, maxBackoff);
+			});
+		};
+
+		function pad(v) {
+			return (v < 10 ? "0" : "") + v;
+		};
+
+		// setOffline shows or hides the indicator of stale data.
+		function setOffline(offline) {
+			var doc = document.getElementById("offline");
+			if (!offline) {
+				doc.style.display = "none";
+				return;
+			}
+			var since = lastData || new Date();
+			doc.textContent = offlineText + " " + pad(since.getHours()) + ":" + pad(since.getMinutes());
+			doc.style.display = "block";
+		};
+
+		window.onload = function() {
+			sync({{.}});
+			connect(0);
+			setInterval(tick, 250);
+			setInterval(function() {
+				if (lastData != null && new Date() - lastData > staleAfter) {
+					setOffline(true);
+				}
+			}, 1000);
+		};
+		</script>
+	</head>
+
+	<body>
+		<div id="offline" class="offline" style="display: none;"></div>
+		<div id="agenda"></div>
+	</body>
+</html>
+{{end}}
+`
+
+const styleTmpl = `{{define "style"}}
 		<style>
 			:host {
 				display: block;
@@ -351,6 +1285,92 @@ const mainPage = `<!DOCTYPE html>
 			.current-contribution {
 				background: #fcb72b;
 			}
+			.current-contribution.ending-contribution {
+				background: #e4572e;
+				color:      #fff;
+			}
+			.progress {
+				height: 8px;
+				margin-top: 4px;
+				background: rgba(0, 0, 0, 0.25);
+				border-radius: 4px;
+				overflow: hidden;
+			}
+			.progress-bar {
+				height: 100%;
+				background: #fff;
+			}
+			.delayed {
+				border-left: 6px solid #e4572e;
+			}
+			.delay {
+				color: #e4572e;
+				font-weight: bold;
+			}
+			.announcement-info, .announcement-warning {
+				padding: 10px;
+				margin-bottom: 4px;
+				border-radius: 5px;
+				font-size: 150%;
+				text-align: center;
+			}
+			.announcement-info {
+				background: #0d4d68;
+				color:      #fff;
+			}
+			.announcement-warning {
+				background: #fcb72b;
+				color:      #000;
+			}
+			.announcement-emergency {
+				position: fixed;
+				top: 0; left: 0; right: 0; bottom: 0;
+				z-index: 100;
+				display: flex;
+				align-items: center;
+				justify-content: center;
+				padding: 5%;
+				background: #c00;
+				color:      #fff;
+				font-size:  400%;
+				font-weight: bold;
+				text-align: center;
+			}
+			.day-nav {
+				margin: 10px 0;
+				text-align: center;
+			}
+			.day-nav a {
+				display: inline-block;
+				padding: 6px 12px;
+				margin: 2px;
+				border-radius: 5px;
+				background: #394c50;
+				color: #fff;
+				text-decoration: none;
+			}
+			.day-nav a.current-day {
+				background: #c7a30a;
+			}
+			.day-title {
+				color: #fff;
+				text-shadow: 4px 3px 5px #000;
+			}
+			.offline {
+				position: fixed;
+				bottom: 10px;
+				left: 10px;
+				z-index: 50;
+				padding: 6px 12px;
+				border-radius: 5px;
+				background: #c00;
+				color: #fff;
+				font-weight: bold;
+			}
+			.countdown {
+				float: right;
+				font-weight: bold;
+			}
 			.contribution-container {
 				padding:    6px;
 				margin-top: 1px;
@@ -375,91 +1395,42 @@ const mainPage = `<!DOCTYPE html>
 				height: 80px;
 			}
 		</style>
+		<link id="theme" rel="stylesheet" href="theme.css">
 		<script type="text/javascript">
-		var sock = null;
-
-		function update(data) {
-			var doc = document.getElementById("agenda");
-			doc.innerHTML = data;
-		};
-
-		window.onload = function() {
-			sock = new WebSocket("ws://{{.Addr}}/data");
-			sock.onmessage = function(event) {
-				update(event.data);
-			};
-		};
+		document.getElementById("theme").href = "theme.css" + window.location.search;
 		</script>
-	</head>
-
-	<body>
-		<div id="agenda"></div>
-	</body>
-</html>
+{{end}}
 `
 
 const agendaTmpl = `{{define "agenda"}}
-<div id="agenda-day" class="clock">{{.Day}}</div>
-<div id="agenda-logo"><img src="/logo" class="logo"></img></div>
+{{- block "announcements" .Announcements}}{{end}}
+<div id="agenda-day" class="clock">{{fmtDate .Now}}<br><span id="clock"></span></div>
+<div id="agenda-logo"><img src="logo" class="logo"></img></div>
 <br style="clear:both;">
 {{block "session" .Sessions}}{{end}}
 {{end}}
 
 {{define "session"}}
 {{- range . }}
-<h2 class="{{.CSSClass}} session-container">{{.Title}} ({{.Start}} - {{.Stop}}) {{if .Room | ne "" }}-- {{.Room}}{{end}}</h2>
+<h2 class="{{.CSSClass}} session-container">{{.Title}} ({{fmtTime .Start}} - {{fmtTime .Stop}}) {{if .Room | ne "" }}-- {{.Room}}{{end}}{{if .Delayed}} <span class="delay">{{tr "delayed"}} {{fmtDuration .Delay}}</span>{{end}}</h2>
 {{- range .Contributions}}
 	<div class="{{.CSSClass}} contribution-container">
-		<h3 class="{{.CSSClass}} contribution-container">{{.Start}} - {{.Stop}}</h3>
-		<b>{{.Title}}</b> (<i>{{.Duration}}</i>)
+		<h3 class="{{.CSSClass}} contribution-container">{{fmtTime .Start}} - {{fmtTime .Stop}}{{if .Active}} <span class="countdown">{{printf (tr "%d min left") .MinutesLeft}}</span>{{end}}</h3>
+		<b>{{.Title}}</b> (<i>{{fmtDuration .Duration}}</i>)
 		{{block "presenters" .Presenters}}{{end}}
+		{{- if .Active}}
+		<div class="progress"><div class="progress-bar" style="width: {{.Progress}}%;"></div></div>
+		{{- end}}
 	</div>
 {{- end}}
 {{- end}}
 {{end}}
 
 {{define "presenters"}}<p>{{displayP .}}</p>{{end}}
-`
-
-func getHostIP() string {
-	host, err := os.Hostname()
-	if err != nil {
-		log.Fatalf("could not retrieve hostname: %v\n", err)
-	}
 
-	addrs, err := net.LookupIP(host)
-	if err != nil {
-		log.Fatalf("could not lookup hostname IP: %v\n", err)
-	}
-
-	for _, addr := range addrs {
-		ipv4 := addr.To4()
-		if ipv4 == nil {
-			continue
-		}
-		return ipv4.String()
-	}
-
-	log.Fatalf("could not infer host IP")
-	return ""
-}
-
-func refreshTime(url string) {
-	beat := 10 * time.Minute
-	ticker := time.NewTicker(beat)
-	defer ticker.Stop()
-
-	url += "/refresh-time"
-	if !strings.HasPrefix(url, "http") {
-		url = "http://" + url
-	}
-	for {
-		select {
-		case <-ticker.C:
-			_, err := http.Post(url, "", nil)
-			if err != nil {
-				log.Printf("error refreshing time: %v\n", err)
-			}
-		}
-	}
-}
+{{define "announcements"}}
+{{- range .}}
+<div class="{{.CSSClass}}" data-targets="{{.TargetList}}">{{.Message}}</div>
+{{- end}}
+{{end}}
+`
@@ -0,0 +1,317 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestServerStress(t *testing.T) {
+	const (
+		workers = 4  // concurrent clients per endpoint
+		rounds  = 10 // requests per client
+	)
+
+	tbl := testTimeTable()
+	clock, err := newSimClock(realClock{}, tbl, time.Time{}, time.Time{}, 60)
+	if err != nil {
+		t.Fatalf("could not create clock: %+v", err)
+	}
+	srv := newServer(":0", tbl, clock)
+	cfg := defaultConfig()
+	cfg.Tick = duration(10 * time.Millisecond)
+	cfg.Admin.Allow = "127.0.0.1"
+	err = srv.configure(&cfg)
+	if err != nil {
+		t.Fatalf("could not configure server: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.start(ctx)
+
+	ts := httptest.NewServer(srv.handler("/"))
+	defer ts.Close()
+
+	get := func(path string) error {
+		resp, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(io.Discard, resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s: invalid status %q", path, resp.Status)
+		}
+		return nil
+	}
+	post := func(path string, form url.Values) error {
+		resp, err := ts.Client().PostForm(ts.URL+path, form)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(io.Discard, resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("POST %s: invalid status %q", path, resp.Status)
+		}
+		return nil
+	}
+	// stream reads the first event streamed from the given path, or gives
+	// up after a while.
+	stream := func(path string) error {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s: invalid status %q", path, resp.Status)
+		}
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if sc.Text() == "" {
+				return nil
+			}
+		}
+		return nil
+	}
+	// display connects a websocket display, and acknowledges a few frames.
+	display := func(i int) error {
+		origin := ts.URL + "/"
+		u := "ws" + strings.TrimPrefix(ts.URL, "http") + fmt.Sprintf("/data?display=stress-%d", i)
+		ws, err := websocket.Dial(u, protoHTML, origin)
+		if err != nil {
+			return err
+		}
+		defer ws.Close()
+		ws.SetDeadline(time.Now().Add(5 * time.Second))
+		for j := 0; j < 3; j++ {
+			var msg string
+			err := websocket.Message.Receive(ws, &msg)
+			if err != nil {
+				return err
+			}
+			err = websocket.Message.Send(ws, "ack")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	actions := []url.Values{
+		{"action": {"pause"}},
+		{"action": {"seek"}, "at": {"2016-10-03T10:40:00Z"}},
+		{"action": {"speed"}, "speed": {"120"}},
+		{"action": {"resume"}},
+	}
+	clients := map[string]func(i, j int) error{
+		"/":           func(i, j int) error { return get("/") },
+		"/api/agenda": func(i, j int) error { return get("/api/agenda?at=2016-10-03T09:45:00Z") },
+		"/delay": func(i, j int) error {
+			return post("/delay", url.Values{"session": {"s1"}, "offset": {fmt.Sprintf("%dm", j%3)}})
+		},
+		"/announce": func(i, j int) error {
+			return post("/announce", url.Values{"message": {fmt.Sprintf("message %d-%d", i, j)}, "ttl": {"1s"}})
+		},
+		"/simulation": func(i, j int) error { return post("/simulation", actions[(i+j)%len(actions)]) },
+		"/data": func(i, j int) error {
+			if j > 0 {
+				return nil
+			}
+			return display(i)
+		},
+		"/events": func(i, j int) error {
+			if j > 0 {
+				return nil
+			}
+			return stream(fmt.Sprintf("/events?display=sse-%d", i))
+		},
+		"/poll": func(i, j int) error { return stream(fmt.Sprintf("/poll?display=poll-%d", i)) },
+	}
+
+	var wg sync.WaitGroup
+	for path, f := range clients {
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(path string, f func(i, j int) error, i int) {
+				defer wg.Done()
+				for j := 0; j < rounds; j++ {
+					err := f(i, j)
+					if err != nil {
+						t.Errorf("%s: client %d, round %d: %+v", path, i, j, err)
+						return
+					}
+				}
+			}(path, f, i)
+		}
+	}
+	wg.Wait()
+
+	// displays still connected are released on shutdown.
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/data", protoHTML, ts.URL+"/")
+	if err != nil {
+		t.Fatalf("could not connect display: %+v", err)
+	}
+	defer ws.Close()
+
+	cancel()
+	wctx, wcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer wcancel()
+	err = srv.wait(wctx)
+	if err != nil {
+		t.Fatalf("server did not shut down: %+v", err)
+	}
+}
+
+func TestGoingAway(t *testing.T) {
+	tbl := testTimeTable()
+	srv := newServer(":0", tbl, fixedClock(at(9, 45)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.start(ctx)
+
+	ts := httptest.NewServer(srv.handler("/"))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial server: %+v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /data HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Origin: %s\r\n\r\n", ts.Listener.Addr(), ts.URL)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("could not read handshake: %+v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("invalid handshake status %q", resp.Status)
+	}
+
+	// readFrame reads a frame sent by the server, which are not masked.
+	readFrame := func() (opcode byte, payload []byte, err error) {
+		var hdr [2]byte
+		_, err = io.ReadFull(r, hdr[:])
+		if err != nil {
+			return 0, nil, err
+		}
+		n := uint64(hdr[1] & 0x7f)
+		switch n {
+		case 126:
+			var buf [2]byte
+			_, err = io.ReadFull(r, buf[:])
+			n = uint64(binary.BigEndian.Uint16(buf[:]))
+		case 127:
+			var buf [8]byte
+			_, err = io.ReadFull(r, buf[:])
+			n = binary.BigEndian.Uint64(buf[:])
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		payload = make([]byte, n)
+		_, err = io.ReadFull(r, payload)
+		return hdr[0] & 0x0f, payload, err
+	}
+
+	// the display is registered once it received its first frame.
+	_, _, err = readFrame()
+	if err != nil {
+		t.Fatalf("could not read first frame: %+v", err)
+	}
+	cancel()
+
+	var closes [][]byte
+	for {
+		op, payload, err := readFrame()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("could not read frame: %+v", err)
+			}
+			break
+		}
+		if op == 0x8 {
+			closes = append(closes, payload)
+		}
+	}
+	if len(closes) != 1 {
+		t.Fatalf("invalid number of close frames: got=%d, want=1 (%q)", len(closes), closes)
+	}
+	if got := binary.BigEndian.Uint16(closes[0]); got != closeGoingAway {
+		t.Fatalf("invalid close status: got=%d, want=%d", got, closeGoingAway)
+	}
+}
+
+func TestEvictStalledClient(t *testing.T) {
+	srv := newServer(":0", testTimeTable(), fixedClock(at(9, 45)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.run(ctx)
+
+	stalled := &client{srv: srv, reg: &srv.reg, name: "stalled", lang: "en", box: newMailbox(), since: time.Now()}
+	alive := &client{srv: srv, reg: &srv.reg, name: "alive", lang: "en", box: newMailbox(), since: time.Now()}
+	for _, c := range []*client{stalled, alive} {
+		if !srv.reg.add(c) {
+			t.Fatalf("could not register client %q", c.name)
+		}
+	}
+
+	// the stalled client did not take its messages for too long.
+	stalled.box.setAgenda([]byte("agenda-0"))
+	stalled.box.mu.Lock()
+	stalled.box.since = time.Now().Add(-2 * deadTimeout)
+	stalled.box.mu.Unlock()
+
+	srv.datac <- frame{"en": []byte("agenda-1")}
+
+	clients := srv.clients()
+	if len(clients) != 1 || clients[0].Name != "alive" {
+		t.Fatalf("invalid clients: %+v", clients)
+	}
+	if got, want := srv.evicted.Load(), uint64(1); got != want {
+		t.Fatalf("invalid number of evicted clients: got=%d, want=%d", got, want)
+	}
+	if data, ok := stalled.box.pop(); ok || string(data) != "agenda-0" {
+		t.Fatalf("invalid stalled mailbox: got=%q (open=%v)", data, ok)
+	}
+	if data, ok := alive.box.pop(); !ok || string(data) != "agenda-1" {
+		t.Fatalf("invalid mailbox: got=%q (open=%v)", data, ok)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clr-info/ji-web-display/indico"
+)
+
+// metricsPrefix is the prefix of the names of the metrics of the server.
+const metricsPrefix = "ji_web_display_"
+
+// metrics holds the counters exposed by the /metrics endpoint, in addition
+// to the state of the server read when they are scraped.
+type metrics struct {
+	broadcasts  atomic.Uint64 // frames broadcast to the displays
+	sent        atomic.Uint64 // messages sent to the displays
+	render      *histogram    // duration of the renderings of the agenda, in seconds
+	fetch       *histogram    // duration of the Indico fetches, in seconds
+	fetchErrors atomic.Uint64 // failed Indico fetches
+	fetchOK     atomic.Int64  // time of the last successful Indico fetch, in seconds since the Unix epoch
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		render: newHistogram(0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1),
+		fetch:  newHistogram(0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30),
+	}
+}
+
+// fetched records an Indico fetch which lasted d and failed with err, if
+// not nil.
+func (m *metrics) fetched(d time.Duration, err error) {
+	m.fetch.observe(d.Seconds())
+	if err != nil {
+		m.fetchErrors.Add(1)
+		return
+	}
+	m.fetchOK.Store(time.Now().Unix())
+}
+
+// histogram is a Prometheus histogram with fixed buckets.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64 // upper bounds of the buckets, in increasing order
+	counts []uint64  // number of observations per bucket, not cumulated
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// fetchTimeTable fetches the timetable of the given event from Indico,
+// recording the outcome in the metrics of the server.
+func (srv *server) fetchTimeTable(id int) (*indico.TimeTable, error) {
+	srv.mu.RLock()
+	host := srv.indico
+	srv.mu.RUnlock()
+
+	start := time.Now()
+	tbl, err := indico.FetchTimeTable(host, id)
+	srv.metrics.fetched(time.Since(start), err)
+	return tbl, err
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+// header writes the HELP and TYPE lines of a metric.
+func (mw metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(mw.w, "# TYPE %s%s %s\n", metricsPrefix, name, typ)
+}
+
+// sample writes a sample of a metric, with labels given as name/value pairs.
+func (mw metricsWriter) sample(name string, v float64, labels ...string) {
+	mw.w.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		mw.w.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteString(",")
+			}
+			fmt.Fprintf(mw.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		mw.w.WriteString("}")
+	}
+	fmt.Fprintf(mw.w, " %s\n", formatFloat(v))
+}
+
+func (mw metricsWriter) gauge(name, help string, v float64) {
+	mw.header(name, "gauge", help)
+	mw.sample(name, v)
+}
+
+func (mw metricsWriter) counter(name, help string, v uint64) {
+	mw.header(name, "counter", help)
+	mw.sample(name, float64(v))
+}
+
+func (mw metricsWriter) histogram(name, help string, h *histogram) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	mw.header(name, "histogram", help)
+	var n uint64
+	for i, bound := range h.bounds {
+		n += h.counts[i]
+		mw.sample(name+"_bucket", float64(n), "le", formatFloat(bound))
+	}
+	mw.sample(name+"_bucket", float64(h.count), "le", "+Inf")
+	mw.sample(name+"_sum", h.sum)
+	mw.sample(name+"_count", float64(h.count))
+}
+
+// labelEscaper escapes label values, as required by the text exposition
+// format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// buildVersion returns the version of the main module of the binary.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "unknown"
+	}
+	return info.Main.Version
+}
+
+// metricsHandler serves the metrics of the server, in the Prometheus text
+// exposition format.
+func (srv *server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	srv.mu.RLock()
+	// only the displays of the configuration are labelled: names given by
+	// the displays themselves would make the number of series unbounded.
+	displays := make(map[string]int, len(srv.profiles))
+	for name := range srv.profiles {
+		displays[name] = 0
+	}
+	srv.mu.RUnlock()
+
+	clients := srv.clients()
+	transports := map[string]int{"websocket": 0, "sse": 0, "poll": 0}
+	for _, c := range clients {
+		transports[c.Transport]++
+		if _, ok := displays[c.Name]; ok {
+			displays[c.Name]++
+		}
+	}
+
+	srv.mu.RLock()
+	var days, sessions, contribs int
+	days = len(srv.ttable.Days)
+	for _, day := range srv.ttable.Days {
+		sessions += len(day.Sessions)
+		for _, s := range day.Sessions {
+			contribs += len(s.Contributions)
+		}
+	}
+	event := srv.ttable.ID
+	source := srv.source
+	loaded := srv.loaded
+	srv.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mw := metricsWriter{w: bufio.NewWriter(w)}
+	defer mw.w.Flush()
+
+	mw.header("build_info", "gauge", "Build information of the server.")
+	mw.sample("build_info", 1, "version", buildVersion(), "goversion", runtime.Version())
+
+	mw.header("clients", "gauge", "Number of connected displays, per transport.")
+	for _, name := range sortedKeys(transports) {
+		mw.sample("clients", float64(transports[name]), "transport", name)
+	}
+	mw.header("display_connections", "gauge", "Number of connections of the displays of the configuration.")
+	for _, name := range sortedKeys(displays) {
+		mw.sample("display_connections", float64(displays[name]), "display", name)
+	}
+
+	mw.counter("broadcasts_total", "Number of frames broadcast to the displays.", srv.metrics.broadcasts.Load())
+	mw.counter("messages_sent_total", "Number of messages sent to the displays.", srv.metrics.sent.Load())
+	mw.counter("messages_dropped_total", "Number of messages replaced before being sent to slow displays.", srv.dropped.Load())
+	mw.counter("clients_evicted_total", "Number of displays evicted for not taking their messages.", srv.evicted.Load())
+	mw.histogram("render_duration_seconds", "Duration of the renderings of the agenda.", srv.metrics.render)
+
+	mw.histogram("indico_fetch_duration_seconds", "Duration of the fetches of the timetable from Indico.", srv.metrics.fetch)
+	mw.counter("indico_fetch_errors_total", "Number of failed fetches of the timetable from Indico.", srv.metrics.fetchErrors.Load())
+	mw.gauge("indico_last_success_timestamp_seconds", "Time of the last successful fetch of the timetable from Indico (0 if none).", float64(srv.metrics.fetchOK.Load()))
+
+	mw.header("timetable_info", "gauge", "Event and origin (indico or embedded) of the timetable.")
+	mw.sample("timetable_info", 1, "event", strconv.Itoa(event), "source", source)
+	mw.gauge("timetable_loaded_timestamp_seconds", "Time at which the timetable was loaded.", float64(loaded.Unix()))
+	mw.gauge("timetable_days", "Number of days of the timetable.", float64(days))
+	mw.gauge("timetable_sessions", "Number of sessions of the timetable.", float64(sessions))
+	mw.gauge("timetable_contributions", "Number of contributions of the timetable.", float64(contribs))
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -5,47 +5,111 @@
 package main
 
 import (
+	"html/template"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/clr-info/ji-web-display/indico"
 )
 
 type Agenda struct {
-	Day      string
-	Sessions []Session
+	Now           time.Time
+	Sessions      []Session
+	Announcements []Announcement
 }
 
 type Session struct {
 	Title         string
 	Room          string
-	Start, Stop   string
+	Start, Stop   time.Time
+	Delay         time.Duration // manual offset declared by the organisers
 	Contributions []Contribution
 	active        bool
 }
 
 func (s Session) CSSClass() string {
+	o := "session"
 	if s.active {
-		return "current-session"
+		o = "current-session"
 	}
-	return "session"
+	if s.Delayed() {
+		o += " delayed"
+	}
+	return o
 }
 
+// Delayed returns whether the session is running late.
+func (s Session) Delayed() bool {
+	return s.Delay != 0
+}
+
+// warnRemaining is the remaining time under which an active contribution
+// is displayed in its "ending" state.
+const warnRemaining = 5 * time.Minute
+
 type Contribution struct {
 	Title      string
-	Start      string
-	Stop       string
+	Start      time.Time
+	Stop       time.Time
 	Duration   time.Duration
+	Elapsed    time.Duration // time elapsed since the start of an active contribution
+	Remaining  time.Duration // time left before the end of an active contribution
+	Delay      time.Duration // manual offset declared by the organisers
 	Presenters []Presenter
 	active     bool
 }
 
 func (c Contribution) CSSClass() string {
-	if c.active {
-		return "current-contribution"
+	o := "contribution"
+	switch {
+	case c.Ending():
+		o = "current-contribution ending-contribution"
+	case c.active:
+		o = "current-contribution"
+	}
+	if c.Delayed() {
+		o += " delayed"
+	}
+	return o
+}
+
+// Delayed returns whether the contribution is running late.
+func (c Contribution) Delayed() bool {
+	return c.Delay != 0
+}
+
+// Active returns whether the contribution is currently taking place.
+func (c Contribution) Active() bool {
+	return c.active
+}
+
+// Ending returns whether the contribution is active and about to end.
+func (c Contribution) Ending() bool {
+	return c.active && c.Remaining <= warnRemaining
+}
+
+// Progress returns the percentage of the contribution already elapsed.
+func (c Contribution) Progress() int {
+	if !c.active || c.Duration <= 0 {
+		return 0
+	}
+	p := int(100 * c.Elapsed / c.Duration)
+	switch {
+	case p < 0:
+		p = 0
+	case p > 100:
+		p = 100
+	}
+	return p
+}
+
+// MinutesLeft returns the number of minutes, rounded up, left for an active
+// contribution.
+func (c Contribution) MinutesLeft() int {
+	if !c.active {
+		return 0
 	}
-	return "contribution"
+	return int((c.Remaining + time.Minute - 1) / time.Minute)
 }
 
 type Presenter struct {
@@ -54,26 +118,75 @@ type Presenter struct {
 	Email       string
 }
 
-func (p Presenter) toHTML() string {
-	o := p.Name
+func (p Presenter) toHTML() template.HTML {
+	o := template.HTMLEscapeString(p.Name)
 	if p.Affiliation != "" {
-		o += " (<em>" + p.Affiliation + "</em>)"
+		o += " (<em>" + template.HTMLEscapeString(p.Affiliation) + "</em>)"
+	}
+	return template.HTML(o)
+}
+
+func newPresenters(ps []indico.Presenter) []Presenter {
+	var o []Presenter
+	for _, p := range ps {
+		o = append(o, Presenter{
+			Name:        p.Name,
+			Affiliation: p.Affiliation,
+			Email:       p.Email,
+		})
 	}
 	return o
 }
 
-func displayPresenters(p []Presenter) string {
-	var o []string
+// displayPresenters returns the HTML list of presenters, built from their
+// escaped names and affiliations.
+func displayPresenters(p []Presenter) template.HTML {
+	var o template.HTML
 	for i, v := range p {
 		if i > 0 {
-			o = append(o, ", ")
+			o += ", "
 		}
-		o = append(o, v.toHTML())
+		o += v.toHTML()
+	}
+	return o
+}
+
+// delays holds the manual "running late" offsets declared by the organisers.
+// Offsets are keyed by Indico session ID or by room name, so they survive
+// timetable refreshes.
+type delays struct {
+	Sessions map[string]time.Duration
+	Rooms    map[string]time.Duration
+}
+
+func newDelays() delays {
+	return delays{
+		Sessions: make(map[string]time.Duration),
+		Rooms:    make(map[string]time.Duration),
 	}
-	return strings.Join(o, "")
 }
 
-func newAgenda(date time.Time, table *indico.TimeTable) Agenda {
+// offset returns the delay applying to the given session.
+// A delay declared for the session takes precedence over one declared for
+// its room.
+func (d delays) offset(s indico.Session) time.Duration {
+	if dt, ok := d.Sessions[s.ID]; ok {
+		return dt
+	}
+	return d.Rooms[s.Room]
+}
+
+// trimSizes sets how much of the agenda is displayed.
+type trimSizes struct {
+	Past          int `json:"past"`          // past sessions displayed before the active one
+	Contributions int `json:"contributions"` // contributions of an active session displayed from the active one
+	Future        int `json:"future"`        // sessions displayed from the active one, before the others are merged
+}
+
+// defaultTrim are the default trim sizes of the agenda.
+var defaultTrim = trimSizes{Past: 1, Contributions: 3, Future: 4}
+
+func newAgenda(date time.Time, table *indico.TimeTable, offsets delays, trim trimSizes) Agenda {
 	var day *indico.Day
 	for i, d := range table.Days {
 		if date.YearDay() == d.Date.YearDay() {
@@ -82,59 +195,67 @@ func newAgenda(date time.Time, table *indico.TimeTable) Agenda {
 	}
 
 	agenda := Agenda{
-		Day: date.Format("2006-01-02<br>15:04:05"),
+		Now: date,
 	}
 
 	if day == nil {
 		return agenda
 	}
 
+	// the timetable is shared with other goroutines: it must not be modified.
+	// its contributions are already sorted by sortTimeTable.
 	for _, s := range day.Sessions {
-		sort.Sort(contrByTime(s.Contributions))
 		var contr []Contribution
-		activeSession := date.Before(s.EndDate) && date.After(s.StartDate)
+		delay := offsets.offset(s)
+		sbeg := s.StartDate.Add(delay)
+		send := s.EndDate.Add(delay)
+		activeSession := date.Before(send) && date.After(sbeg)
 		for _, c := range s.Contributions {
 			if !activeSession {
 				continue
 			}
-			if c.EndDate.Before(date) {
+			cbeg := c.StartDate.Add(delay)
+			cend := c.EndDate.Add(delay)
+			if cend.Before(date) {
 				continue
 			}
-			var p []Presenter
-			for _, pp := range c.Presenters {
-				p = append(p, Presenter{
-					Name:        pp.Name,
-					Affiliation: pp.Affiliation,
-					Email:       pp.Email,
-				})
+			activeContr := date.Before(cend) && date.After(cbeg)
+			var elapsed, remaining time.Duration
+			if activeContr {
+				elapsed = date.Sub(cbeg).Truncate(time.Second)
+				remaining = cend.Sub(date).Truncate(time.Second)
 			}
-			activeContr := date.Before(c.EndDate) && date.After(c.StartDate)
 			contr = append(contr, Contribution{
 				Title:      c.Title,
-				Start:      c.StartDate.Format("15:04"),
-				Stop:       c.EndDate.Format("15:04"),
+				Start:      cbeg,
+				Stop:       cend,
 				Duration:   c.Duration,
-				Presenters: p,
+				Elapsed:    elapsed,
+				Remaining:  remaining,
+				Delay:      delay,
+				Presenters: newPresenters(c.Presenters),
 				active:     activeContr,
 			})
 		}
 		agenda.Sessions = append(agenda.Sessions, Session{
 			Title:         s.Title,
 			Room:          s.Room,
-			Start:         s.StartDate.Format("15:04"),
-			Stop:          s.EndDate.Format("15:04"),
+			Start:         sbeg,
+			Stop:          send,
+			Delay:         delay,
 			Contributions: contr,
 			active:        activeSession,
 		})
 	}
-	trimPastSessions(&agenda)
-	trimActiveSessions(&agenda)
-	trimFutureSessions(&agenda)
+	trimPastSessions(&agenda, trim.Past)
+	trimActiveSessions(&agenda, trim.Contributions)
+	trimFutureSessions(&agenda, trim.Future)
 	return agenda
 }
 
-// trimPastSessions removes unnecessary past sessions
-func trimPastSessions(agenda *Agenda) {
+// trimPastSessions removes unnecessary past sessions, keeping head of them
+// before the active one.
+func trimPastSessions(agenda *Agenda, head int) {
 	idx := -1
 	for i, s := range agenda.Sessions {
 		if s.active {
@@ -142,7 +263,6 @@ func trimPastSessions(agenda *Agenda) {
 			break
 		}
 	}
-	const head = 1
 	if idx > head {
 		i := idx - head
 		if i < 0 {
@@ -152,8 +272,9 @@ func trimPastSessions(agenda *Agenda) {
 	}
 }
 
-// trimActiveSessions removes unnecessary contributions of active sessions
-func trimActiveSessions(agenda *Agenda) {
+// trimActiveSessions removes unnecessary contributions of active sessions,
+// merging the ones after the n first from the active one.
+func trimActiveSessions(agenda *Agenda, n int) {
 	for ii, s := range agenda.Sessions {
 		if !s.active {
 			continue
@@ -164,8 +285,8 @@ func trimActiveSessions(agenda *Agenda) {
 				idx = i
 			}
 		}
-		if len(s.Contributions)-idx > 3 {
-			i := idx + 3
+		if len(s.Contributions)-idx > n {
+			i := idx + n
 			merged := Contribution{
 				Title: " ... ",
 				Start: s.Contributions[i].Start,
@@ -183,16 +304,17 @@ func trimActiveSessions(agenda *Agenda) {
 	}
 }
 
-// trimFutureSessions removes unnecessary future sessions
-func trimFutureSessions(agenda *Agenda) {
+// trimFutureSessions removes unnecessary future sessions, merging the ones
+// after the n first from the active one.
+func trimFutureSessions(agenda *Agenda, n int) {
 	idx := len(agenda.Sessions)
 	for i, s := range agenda.Sessions {
 		if s.active {
 			idx = i
 		}
 	}
-	if len(agenda.Sessions)-idx > 4 {
-		i := idx + 4
+	if len(agenda.Sessions)-idx > n {
+		i := idx + n
 		merged := Session{
 			Title: " ... ",
 			Start: agenda.Sessions[i].Start,
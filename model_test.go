@@ -0,0 +1,257 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisplayPresenters(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    []Presenter
+		want template.HTML
+	}{
+		{
+			name: "empty",
+			want: "",
+		},
+		{
+			name: "plain",
+			p:    []Presenter{{Name: "Marie Curie", Affiliation: "IRSN"}},
+			want: "Marie Curie (<em>IRSN</em>)",
+		},
+		{
+			name: "no affiliation",
+			p:    []Presenter{{Name: "Marie Curie"}, {Name: "Pierre Curie"}},
+			want: "Marie Curie, Pierre Curie",
+		},
+		{
+			name: "script name",
+			p:    []Presenter{{Name: "<script>alert(1)</script>", Affiliation: "CNRS"}},
+			want: "&lt;script&gt;alert(1)&lt;/script&gt; (<em>CNRS</em>)",
+		},
+		{
+			name: "script affiliation",
+			p:    []Presenter{{Name: "Eve", Affiliation: "</em><script>alert(1)</script>"}},
+			want: "Eve (<em>&lt;/em&gt;&lt;script&gt;alert(1)&lt;/script&gt;</em>)",
+		},
+		{
+			name: "ampersand",
+			p:    []Presenter{{Name: "A & B", Affiliation: "C&D"}, {Name: `"Q" 'R'`}},
+			want: "A &amp; B (<em>C&amp;D</em>), &#34;Q&#34; &#39;R&#39;",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := displayPresenters(tc.p)
+			if got != tc.want {
+				t.Fatalf("invalid HTML:\ngot= %q\nwant=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAgendaEscaping(t *testing.T) {
+	const hostile = "<script>alert(1)</script>"
+	now := time.Date(2016, 10, 3, 10, 30, 0, 0, time.UTC)
+	data := Agenda{
+		Now: now,
+		Sessions: []Session{{
+			Title: "Session " + hostile,
+			Room:  "Room " + hostile,
+			Start: now.Add(-30 * time.Minute),
+			Stop:  now.Add(30 * time.Minute),
+			Contributions: []Contribution{{
+				Title:      "Talk " + hostile,
+				Start:      now.Add(-10 * time.Minute),
+				Stop:       now.Add(10 * time.Minute),
+				Duration:   20 * time.Minute,
+				Elapsed:    10 * time.Minute,
+				Remaining:  10 * time.Minute,
+				Presenters: []Presenter{{Name: "A & B", Affiliation: hostile}},
+				active:     true,
+			}},
+			active: true,
+		}},
+		Announcements: []Announcement{{
+			ID:      1,
+			Message: "Coffee " + hostile,
+			Targets: []string{`"><script>alert(1)</script>`},
+		}},
+	}
+
+	srv := newServer(":0", nil, realClock{})
+	for name, tmpl := range srv.tmpls {
+		buf := new(bytes.Buffer)
+		err := tmpl.ExecuteTemplate(buf, "agenda", data)
+		if err != nil {
+			t.Fatalf("%s: could not render agenda: %+v", name, err)
+		}
+		out := buf.String()
+		if strings.Contains(out, "<script>") {
+			t.Fatalf("%s: unescaped script in agenda:\n%s", name, out)
+		}
+		for _, want := range []string{
+			"Session &lt;script&gt;alert(1)&lt;/script&gt;",
+			"Room &lt;script&gt;alert(1)&lt;/script&gt;",
+			"Talk &lt;script&gt;alert(1)&lt;/script&gt;",
+			"Coffee &lt;script&gt;alert(1)&lt;/script&gt;",
+			"A &amp; B (<em>&lt;script&gt;alert(1)&lt;/script&gt;</em>)",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: agenda does not contain %q:\n%s", name, want, out)
+			}
+		}
+	}
+}
+
+func TestNewAgenda(t *testing.T) {
+	tbl := testTimeTable()
+	delayed := newDelays()
+	delayed.Sessions["s1"] = 10 * time.Minute
+
+	for _, tc := range []struct {
+		name     string
+		clock    Clock
+		delays   delays
+		sessions []string
+		contribs []string // contributions of the active session
+		active   string   // active contribution
+		progress int
+		left     int
+	}{
+		{
+			name:     "active",
+			clock:    fixedClock(at(9, 45)),
+			delays:   newDelays(),
+			sessions: []string{"Morning", "Noon"},
+			contribs: []string{"B"},
+			active:   "B",
+			progress: 50,
+			left:     15,
+		},
+		{
+			name:     "delayed",
+			clock:    fixedClock(at(9, 45)),
+			delays:   delayed,
+			sessions: []string{"Morning", "Noon"},
+			contribs: []string{"B"},
+			active:   "B",
+			progress: 16,
+			left:     25,
+		},
+		{
+			name:     "offset",
+			clock:    newOffsetClock(fixedClock(at(0, 0)), at(10, 40)),
+			delays:   newDelays(),
+			sessions: []string{"Morning", "Noon"},
+			contribs: []string{"C", "D"},
+			active:   "C",
+			progress: 33,
+			left:     20,
+		},
+		{
+			name:     "between sessions",
+			clock:    fixedClock(at(10, 15)),
+			delays:   newDelays(),
+			sessions: []string{"Morning", "Noon"},
+		},
+		{
+			name:   "other day",
+			clock:  fixedClock(at(10, 15).AddDate(0, 0, 1)),
+			delays: newDelays(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			agenda := newAgenda(tc.clock.Now(), tbl, tc.delays, defaultTrim)
+			var sessions []string
+			for _, s := range agenda.Sessions {
+				sessions = append(sessions, s.Title)
+			}
+			if got, want := strings.Join(sessions, ","), strings.Join(tc.sessions, ","); got != want {
+				t.Fatalf("invalid sessions: got=%q, want=%q", got, want)
+			}
+			var contribs []string
+			var active *Contribution
+			for _, s := range agenda.Sessions {
+				for i, c := range s.Contributions {
+					contribs = append(contribs, c.Title)
+					if c.Active() {
+						active = &s.Contributions[i]
+					}
+				}
+			}
+			if got, want := strings.Join(contribs, ","), strings.Join(tc.contribs, ","); got != want {
+				t.Fatalf("invalid contributions: got=%q, want=%q", got, want)
+			}
+			if active == nil {
+				if tc.active != "" {
+					t.Fatalf("no active contribution, want %q", tc.active)
+				}
+				return
+			}
+			if active.Title != tc.active {
+				t.Fatalf("invalid active contribution: got=%q, want=%q", active.Title, tc.active)
+			}
+			if got := active.Progress(); got != tc.progress {
+				t.Errorf("invalid progress: got=%d, want=%d", got, tc.progress)
+			}
+			if got := active.MinutesLeft(); got != tc.left {
+				t.Errorf("invalid minutes left: got=%d, want=%d", got, tc.left)
+			}
+		})
+	}
+}
+
+func TestContributionProgress(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		c        Contribution
+		progress int
+		left     int
+	}{
+		{
+			name: "inactive",
+			c:    Contribution{Duration: 20 * time.Minute, Elapsed: 10 * time.Minute, Remaining: 10 * time.Minute},
+		},
+		{
+			name:     "started",
+			c:        Contribution{Duration: 20 * time.Minute, Remaining: 20 * time.Minute, active: true},
+			progress: 0,
+			left:     20,
+		},
+		{
+			name:     "rounded up",
+			c:        Contribution{Duration: 20 * time.Minute, Elapsed: 5*time.Minute + 30*time.Second, Remaining: 14*time.Minute + 30*time.Second, active: true},
+			progress: 27,
+			left:     15,
+		},
+		{
+			name:     "overrun",
+			c:        Contribution{Duration: 20 * time.Minute, Elapsed: 25 * time.Minute, active: true},
+			progress: 100,
+			left:     0,
+		},
+		{
+			name:     "no duration",
+			c:        Contribution{Remaining: time.Second, active: true},
+			progress: 0,
+			left:     1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.c.Progress(); got != tc.progress {
+				t.Errorf("invalid progress: got=%d, want=%d", got, tc.progress)
+			}
+			if got := tc.c.MinutesLeft(); got != tc.left {
+				t.Errorf("invalid minutes left: got=%d, want=%d", got, tc.left)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clr-info/ji-web-display/indico"
+)
+
+const dayLayout = "2006-01-02"
+
+// Programme is the full, untrimmed, schedule of one or more days.
+type Programme struct {
+	Days       []DayProgramme // days displayed
+	Nav        []DayLink      // links to all the days of the event
+	Prev, Next string         // IDs of the previous and next days, if any
+}
+
+// DayProgramme is the full schedule of a day.
+type DayProgramme struct {
+	Date     time.Time
+	Sessions []Session
+}
+
+// ID returns the identifier of the day, as used in /day/ URLs.
+func (d DayProgramme) ID() string {
+	return d.Date.Format(dayLayout)
+}
+
+// DayLink is a navigation link to the programme of a day.
+type DayLink struct {
+	ID      string
+	Date    time.Time
+	Current bool
+}
+
+func newProgramme(table *indico.TimeTable, offsets delays) Programme {
+	var prog Programme
+	for _, day := range table.Days {
+		d := newDayProgramme(day, offsets)
+		prog.Days = append(prog.Days, d)
+		prog.Nav = append(prog.Nav, DayLink{
+			ID:   d.ID(),
+			Date: d.Date,
+		})
+	}
+	return prog
+}
+
+// dayProgramme returns the programme restricted to the day with the given ID,
+// and whether such a day exists.
+func (prog Programme) dayProgramme(id string) (Programme, bool) {
+	o := Programme{
+		Nav: make([]DayLink, len(prog.Nav)),
+	}
+	copy(o.Nav, prog.Nav)
+	for i, d := range prog.Days {
+		if d.ID() != id {
+			continue
+		}
+		o.Days = []DayProgramme{d}
+		o.Nav[i].Current = true
+		if i > 0 {
+			o.Prev = prog.Days[i-1].ID()
+		}
+		if i+1 < len(prog.Days) {
+			o.Next = prog.Days[i+1].ID()
+		}
+		return o, true
+	}
+	return o, false
+}
+
+func newDayProgramme(day indico.Day, offsets delays) DayProgramme {
+	o := DayProgramme{Date: day.Date}
+	for _, s := range day.Sessions {
+		delay := offsets.offset(s)
+		sess := Session{
+			Title: s.Title,
+			Room:  s.Room,
+			Start: s.StartDate.Add(delay),
+			Stop:  s.EndDate.Add(delay),
+			Delay: delay,
+		}
+		for _, c := range s.Contributions {
+			sess.Contributions = append(sess.Contributions, Contribution{
+				Title:      c.Title,
+				Start:      c.StartDate.Add(delay),
+				Stop:       c.EndDate.Add(delay),
+				Duration:   c.Duration,
+				Delay:      delay,
+				Presenters: newPresenters(c.Presenters),
+			})
+		}
+		o.Sessions = append(o.Sessions, sess)
+	}
+	return o
+}
+
+// programmeHandler serves the full programme of the event.
+func (srv *server) programmeHandler(w http.ResponseWriter, r *http.Request) {
+	srv.mu.RLock()
+	prog := newProgramme(srv.ttable, srv.delays)
+	srv.mu.RUnlock()
+
+	err := srv.template(r).ExecuteTemplate(w, "programme", prog)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// dayHandler serves the full programme of the day given in the URL,
+// as in /day/2016-09-27.
+// Requests for /day/ are redirected to the first day of the event.
+func (srv *server) dayHandler(w http.ResponseWriter, r *http.Request) {
+	srv.mu.RLock()
+	prog := newProgramme(srv.ttable, srv.delays)
+	srv.mu.RUnlock()
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/day/"), "/")
+	if id == "" {
+		if len(prog.Days) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, srv.base+srv.locale(r).link("day/"+prog.Days[0].ID()), http.StatusFound)
+		return
+	}
+
+	day, ok := prog.dayProgramme(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := srv.template(r).ExecuteTemplate(w, "programme", day)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+const programmeTmpl = `{{define "programme"}}<!DOCTYPE html>
+<html lang="{{lang}}">
+	<head>
+		<meta name="viewport" content="width=device-width, minimum-scale=1.0, initial-scale=1.0, user-scalable=yes">
+		<meta charset="utf-8">
+		<title>JI-2016 {{tr "Programme"}}</title>
+		<base href="{{base}}">
+		{{template "style"}}
+	</head>
+
+	<body>
+		<div id="agenda-logo"><img src="logo" class="logo"></img></div>
+		{{template "day-nav" .}}
+		{{- range .Days}}
+		<h1 class="day-title">{{fmtDay .Date}}</h1>
+		{{template "session" .Sessions}}
+		{{- end}}
+		{{template "day-nav" .}}
+	</body>
+</html>
+{{end}}
+
+{{define "day-nav"}}
+<div class="day-nav">
+	<a href="{{link "./"}}">{{tr "Now"}}</a>
+	{{- if .Prev}}
+	<a href="{{link (print "day/" .Prev)}}">&larr;</a>
+	{{- end}}
+	{{- range .Nav}}
+	<a href="{{link (print "day/" .ID)}}"{{if .Current}} class="current-day"{{end}}>{{fmtShortDay .Date}}</a>
+	{{- end}}
+	{{- if .Next}}
+	<a href="{{link (print "day/" .Next)}}">&rarr;</a>
+	{{- end}}
+	<a href="{{link "programme"}}">{{tr "Programme"}}</a>
+</div>
+{{end}}
+`
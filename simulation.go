@@ -0,0 +1,240 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clr-info/ji-web-display/indico"
+)
+
+// span is a period of simulated time.
+type span struct {
+	start time.Time
+	end   time.Time
+}
+
+// simClock is the clock of the simulation mode.
+// It runs speed times faster than a base clock over a list of spans of time,
+// skipping the time between them, and loops back to the first span after the
+// last one.
+type simClock struct {
+	base   Clock
+	spans  []span
+	total  time.Duration // total duration of the spans
+	origin time.Time     // time of the base clock at pos
+	pos    time.Duration // position in the spans at origin
+	speed  float64
+	paused bool
+
+	start, end time.Time // period the simulation is restricted to, if not zero
+}
+
+// newSimClock returns a simulation clock running over the days of the given
+// timetable, restricted to the [start, end) period when these are not zero.
+// When the timetable has no session in that period, the simulation runs over
+// the whole period.
+func newSimClock(base Clock, table *indico.TimeTable, start, end time.Time, speed float64) (*simClock, error) {
+	if !validSpeed(speed) {
+		return nil, fmt.Errorf("invalid simulation speed %v", speed)
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return nil, fmt.Errorf("invalid simulation period [%v, %v)", start, end)
+	}
+
+	var spans []span
+	for _, day := range table.Days {
+		var sp span
+		for _, s := range day.Sessions {
+			if sp.start.IsZero() || s.StartDate.Before(sp.start) {
+				sp.start = s.StartDate
+			}
+			if s.EndDate.After(sp.end) {
+				sp.end = s.EndDate
+			}
+		}
+		if !start.IsZero() && sp.start.Before(start) {
+			sp.start = start
+		}
+		if !end.IsZero() && sp.end.After(end) {
+			sp.end = end
+		}
+		if !sp.start.Before(sp.end) {
+			continue
+		}
+		spans = append(spans, sp)
+	}
+	if len(spans) == 0 {
+		if start.IsZero() || end.IsZero() {
+			return nil, fmt.Errorf("no simulation period")
+		}
+		spans = []span{{start: start, end: end}}
+	}
+
+	clock := &simClock{
+		base:   base,
+		spans:  spans,
+		origin: base.Now(),
+		speed:  speed,
+		start:  start,
+		end:    end,
+	}
+	for _, sp := range spans {
+		clock.total += sp.end.Sub(sp.start)
+	}
+	return clock, nil
+}
+
+// Now returns the simulated time.
+func (c *simClock) Now() time.Time {
+	pos := c.pos
+	if !c.paused {
+		pos += time.Duration(float64(c.base.Now().Sub(c.origin)) * c.speed)
+	}
+	return c.at(pos)
+}
+
+// at returns the simulated time at the given position in the spans.
+func (c *simClock) at(pos time.Duration) time.Time {
+	pos %= c.total
+	if pos < 0 {
+		pos += c.total
+	}
+	for _, sp := range c.spans {
+		d := sp.end.Sub(sp.start)
+		if pos < d {
+			return sp.start.Add(pos)
+		}
+		pos -= d
+	}
+	return c.spans[0].start
+}
+
+// position returns the position in the spans of the given time.
+// Times between spans are moved to the start of the next span.
+func (c *simClock) position(t time.Time) time.Duration {
+	var pos time.Duration
+	for _, sp := range c.spans {
+		if t.Before(sp.end) {
+			if t.After(sp.start) {
+				pos += t.Sub(sp.start)
+			}
+			return pos
+		}
+		pos += sp.end.Sub(sp.start)
+	}
+	return 0
+}
+
+// The following methods return a modified copy of the clock, so the clock
+// of the server can be replaced atomically.
+
+// pause returns the clock stopped at the current simulated time.
+func (c *simClock) pause() *simClock {
+	o := *c
+	o.pos = o.position(c.Now())
+	o.origin = c.base.Now()
+	o.paused = true
+	return &o
+}
+
+// resume returns the clock running again from the current simulated time.
+func (c *simClock) resume() *simClock {
+	o := c.pause()
+	o.paused = false
+	return o
+}
+
+// seek returns the clock moved to the given simulated time.
+func (c *simClock) seek(t time.Time) *simClock {
+	o := c.pause()
+	o.pos = o.position(t)
+	o.paused = c.paused
+	return o
+}
+
+// validSpeed reports whether speed is a usable speed factor for a simulation.
+func validSpeed(speed float64) bool {
+	return !math.IsNaN(speed) && !math.IsInf(speed, 0) && speed > 0
+}
+
+// withSpeed returns the clock running at the given speed.
+func (c *simClock) withSpeed(speed float64) *simClock {
+	o := c.pause()
+	o.speed = speed
+	o.paused = c.paused
+	return o
+}
+
+// withTable returns the clock running over the days of the given timetable,
+// from the current simulated time.
+func (c *simClock) withTable(table *indico.TimeTable) (*simClock, error) {
+	o, err := newSimClock(c.base, table, c.start, c.end, c.speed)
+	if err != nil {
+		return nil, err
+	}
+	o.paused = c.paused
+	return o.seek(c.Now()), nil
+}
+
+// simulationHandler pauses, resumes or moves the simulation, as selected by
+// the "action" form value:
+//   - pause: pause the simulation,
+//   - resume: resume the simulation,
+//   - seek: move the simulation to the time given by the "at" form value,
+//   - speed: change the speed of the simulation to the "speed" form value.
+func (srv *server) simulationHandler(w http.ResponseWriter, r *http.Request) {
+	var update func(c *simClock) *simClock
+	switch action := r.FormValue("action"); action {
+	case "pause":
+		update = (*simClock).pause
+	case "resume":
+		update = (*simClock).resume
+	case "seek":
+		at, err := parseTime(r.FormValue("at"), srv.loc)
+		if err != nil {
+			http.Error(w, "invalid time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		update = func(c *simClock) *simClock { return c.seek(at) }
+	case "speed":
+		speed, err := strconv.ParseFloat(r.FormValue("speed"), 64)
+		if err != nil || !validSpeed(speed) {
+			http.Error(w, fmt.Sprintf("invalid speed %q", r.FormValue("speed")), http.StatusBadRequest)
+			return
+		}
+		update = func(c *simClock) *simClock { return c.withSpeed(speed) }
+	default:
+		http.Error(w, fmt.Sprintf("invalid action %q", action), http.StatusBadRequest)
+		return
+	}
+
+	// the clock is updated under the lock, so concurrent requests do not
+	// override each other.
+	srv.mu.Lock()
+	clock, ok := srv.clock.(*simClock)
+	if ok {
+		clock = update(clock)
+		srv.clock = clock
+	}
+	srv.mu.Unlock()
+	if !ok {
+		http.Error(w, "simulation mode disabled", http.StatusConflict)
+		return
+	}
+	srv.kick()
+
+	state := "running"
+	if clock.paused {
+		state = "paused"
+	}
+	slog.Info("simulation "+state, "now", clock.Now(), "speed", clock.speed)
+	fmt.Fprintf(w, "simulation %s at %v (x%v)\n", state, clock.Now(), clock.speed)
+}
@@ -0,0 +1,67 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulationSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		speed string
+		want  int
+	}{
+		{speed: "2", want: http.StatusOK},
+		{speed: "0.5", want: http.StatusOK},
+		{speed: "0", want: http.StatusBadRequest},
+		{speed: "-1", want: http.StatusBadRequest},
+		{speed: "NaN", want: http.StatusBadRequest},
+		{speed: "Inf", want: http.StatusBadRequest},
+		{speed: "-Inf", want: http.StatusBadRequest},
+		{speed: "1e400", want: http.StatusBadRequest},
+		{speed: "fast", want: http.StatusBadRequest},
+	} {
+		t.Run(tc.speed, func(t *testing.T) {
+			clock, err := newSimClock(fixedClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), testTimeTable(), time.Time{}, time.Time{}, 60)
+			if err != nil {
+				t.Fatalf("could not create clock: %+v", err)
+			}
+			srv := newServer(":0", testTimeTable(), clock)
+
+			form := url.Values{"action": {"speed"}, "speed": {tc.speed}}
+			req := httptest.NewRequest(http.MethodPost, "/simulation", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			srv.simulationHandler(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("invalid status: got=%d, want=%d (%s)", rec.Code, tc.want, rec.Body)
+			}
+
+			// the displays can always be synchronized on the clock.
+			if _, err := srv.clockSync().encode(); err != nil {
+				t.Fatalf("could not encode clock: %+v", err)
+			}
+		})
+	}
+
+	for _, speed := range []float64{0, -1, math.NaN(), math.Inf(+1), math.Inf(-1)} {
+		_, err := newSimClock(realClock{}, testTimeTable(), time.Time{}, time.Time{}, speed)
+		if err == nil {
+			t.Fatalf("expected an error for speed %v", speed)
+		}
+
+		cfg := defaultConfig()
+		cfg.Sim.Speed = speed
+		if err := cfg.validate(); err == nil {
+			t.Fatalf("expected an invalid configuration for speed %v", speed)
+		}
+	}
+}
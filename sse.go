@@ -0,0 +1,157 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// pollTimeout is the maximum duration a long-polling request waits for a frame.
+const pollTimeout = 30 * time.Second
+
+// newHTTPClient returns a client receiving the frames broadcast to the
+// displays over a plain HTTP request, with the given transport.
+// The representation of the agenda is selected by the "lang" and "format"
+// parameters of the request.
+func (srv *server) newHTTPClient(r *http.Request, via string) *client {
+	return &client{
+		srv:   srv,
+		reg:   &srv.reg,
+		box:   newMailbox(),
+		addr:  r.RemoteAddr,
+		name:  r.FormValue("display"),
+		agent: r.UserAgent(),
+		lang:  srv.locale(r).Name,
+		json:  r.FormValue("format") == "json",
+		since: time.Now(),
+		via:   via,
+
+		targeted: r.FormValue("room") != "" || r.FormValue("screen") != "",
+	}
+}
+
+// eventsHandler streams the agenda as Server-Sent Events, for displays which
+// cannot use websockets.
+func (srv *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := srv.newHTTPClient(r, "sse")
+	if !c.reg.add(c) {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer c.Release()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	flusher.Flush()
+
+	rc := http.NewResponseController(w)
+	c.run(func(data []byte) error {
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		err := writeEvent(w, data)
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		c.acked()
+		return r.Context().Err()
+	})
+}
+
+// writeEvent writes data as a single Server-Sent Event.
+func writeEvent(w io.Writer, data []byte) error {
+	buf := new(bytes.Buffer)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pollHandler serves the next frame broadcast to the displays, for displays
+// which can neither use websockets nor Server-Sent Events.
+// Frames are served with an ETag: the last frame broadcast is served right
+// away, unless it matches the If-None-Match header of the request.
+// pollHandler replies with a clock sync when no frame was broadcast before
+// pollTimeout.
+func (srv *server) pollHandler(w http.ResponseWriter, r *http.Request) {
+	c := srv.newHTTPClient(r, "poll")
+	if !c.reg.add(c) {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer c.Release()
+
+	timeout := time.NewTimer(pollTimeout)
+	defer timeout.Stop()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		data, ok := c.box.pop()
+		if data == nil {
+			if !ok {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			select {
+			case <-c.box.ready:
+			case <-timeout.C:
+				buf, err := srv.clockSync().encode()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.Write(buf)
+				return
+			case <-r.Context().Done():
+				return
+			}
+			continue
+		}
+
+		switch {
+		case isControl(data):
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		default:
+			etag := fmt.Sprintf("%q", fmt.Sprintf("%x", sha256.Sum256(data)))
+			if etag == r.Header.Get("If-None-Match") {
+				continue
+			}
+			w.Header().Set("ETag", etag)
+			if c.json {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			}
+		}
+		_, err := w.Write(data)
+		if err == nil {
+			c.acked()
+			srv.metrics.sent.Add(1)
+		}
+		return
+	}
+}
+
+// isControl returns whether data is a control message (e.g. a clock sync or
+// a display command) rather than an agenda.
+func isControl(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(`{"control":`))
+}
@@ -0,0 +1,287 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// parseTemplates parses the built-in templates, overridden by the *.tmpl
+// files of the given directory, if any, and returns them per locale.
+// The given funcs are made available to the templates, alongside the
+// functions of each locale.
+// parseTemplates also returns the built-in themes, overridden or extended by
+// the *.css files of the directory.
+func parseTemplates(dir string, funcs template.FuncMap) (map[string]*template.Template, map[string]string, error) {
+	tmpl, err := template.New("ji-web").Funcs(locales["en"].funcs()).Funcs(funcs).Parse(
+		mainPage + styleTmpl + agendaTmpl + programmeTmpl + adminTmpl,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	themes := make(map[string]string, len(builtinThemes))
+	for k, v := range builtinThemes {
+		themes[k] = v
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(files) > 0 {
+			tmpl, err = tmpl.ParseFiles(files...)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		files, err = filepath.Glob(filepath.Join(dir, "*.css"))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, fname := range files {
+			css, err := ioutil.ReadFile(fname)
+			if err != nil {
+				return nil, nil, err
+			}
+			themes[strings.TrimSuffix(filepath.Base(fname), ".css")] = string(css)
+		}
+	}
+
+	tmpls := make(map[string]*template.Template, len(locales))
+	for name, loc := range locales {
+		t, err := tmpl.Clone()
+		if err != nil {
+			return nil, nil, err
+		}
+		tmpls[name] = t.Funcs(loc.funcs())
+	}
+	return tmpls, themes, nil
+}
+
+// loadTemplates loads the templates and themes from the given directory.
+// The themes of the configuration take precedence over the ones of the
+// directory.
+func (srv *server) loadTemplates(dir string) error {
+	tmpls, themes, err := parseTemplates(dir, srv.funcs())
+	if err != nil {
+		return err
+	}
+	srv.tmu.Lock()
+	for name, css := range srv.css {
+		themes[name] = css
+	}
+	srv.tmplDir = dir
+	srv.tmpls = tmpls
+	srv.themes = themes
+	srv.tmu.Unlock()
+	return nil
+}
+
+// watchTemplates reloads the templates and themes whenever a file of the
+// given directory is modified, until ctx is done.
+func (srv *server) watchTemplates(ctx context.Context, dir string) {
+	beat := 1 * time.Second
+	ticker := time.NewTicker(beat)
+	defer ticker.Stop()
+
+	last := lastModTime(dir)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		mod := lastModTime(dir)
+		if !mod.After(last) {
+			continue
+		}
+		last = mod
+		slog.Info("reloading templates...", "dir", dir)
+		err := srv.loadTemplates(dir)
+		if err != nil {
+			slog.Error("error reloading templates", "dir", dir, "error", err)
+			continue
+		}
+		srv.kick()
+		slog.Info("reloading templates... [done]", "dir", dir)
+	}
+}
+
+// lastModTime returns the modification time of the most recently
+// modified file of dir.
+func lastModTime(dir string) time.Time {
+	var last time.Time
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return last
+	}
+	for _, fi := range files {
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	if fi, err := os.Stat(dir); err == nil && fi.ModTime().After(last) {
+		// catch removed files.
+		last = fi.ModTime()
+	}
+	return last
+}
+
+// themeHandler serves the stylesheet of the theme requested by the "theme"
+// parameter, or of the theme of the profile of the display, or of the
+// default theme of the server.
+func (srv *server) themeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("theme")
+	if name == "" {
+		name = srv.profile(r.URL.Query().Get("display")).Theme
+	}
+
+	srv.tmu.RLock()
+	if name == "" {
+		name = srv.theme
+	}
+	css, ok := srv.themes[name]
+	srv.tmu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no theme named %q", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, css)
+}
+
+// builtinThemes holds the stylesheets of the built-in themes.
+// Themes are applied on top of the "style" template.
+// Themes styling .current-contribution also style
+// .current-contribution.ending-contribution, so the contributions about to
+// end keep standing out.
+var builtinThemes = map[string]string{
+	"default": "",
+
+	"dark": `
+body {
+	background: #0b0b0b;
+	color: #ddd;
+}
+.session {
+	background: #1e2628;
+}
+.current-session {
+	background: #6b5806;
+}
+.contribution {
+	background: #1b2b2b;
+	color:      #ccc;
+}
+.current-contribution {
+	background: #8a6d0b;
+	color:      #fff;
+}
+.current-contribution.ending-contribution {
+	background: #a8321a;
+	color:      #fff;
+}
+.clock {
+	background: #000;
+	color:      #aaa;
+}
+`,
+
+	"high-contrast": `
+body {
+	background: #000;
+	color: #fff;
+	font-weight: 400;
+}
+.session-container, .contribution-container {
+	border: 2px solid #fff;
+}
+.session, .current-session {
+	text-shadow: none;
+}
+.session {
+	background: #000;
+	color:      #fff;
+}
+.current-session {
+	background: #ff0;
+	color:      #000;
+}
+.contribution {
+	background: #000;
+	color:      #fff;
+}
+.current-contribution {
+	background: #fff;
+	color:      #000;
+}
+.current-contribution.ending-contribution {
+	background: #f00;
+	color:      #fff;
+}
+.progress-bar {
+	background: #0f0;
+}
+`,
+
+	"light": `
+body {
+	background: #f4f4f4;
+	color: #222;
+}
+.session, .current-session {
+	text-shadow: none;
+}
+.session {
+	background: #dde6e8;
+	color:      #222;
+}
+.current-session {
+	background: #f3d77a;
+	color:      #222;
+}
+.contribution {
+	background: #eef3f3;
+	color:      #333;
+}
+.current-contribution {
+	background: #fde3a7;
+	color:      #222;
+}
+.current-contribution.ending-contribution {
+	background: #f4a582;
+	color:      #222;
+}
+.progress {
+	background: rgba(0, 0, 0, 0.1);
+}
+.progress-bar {
+	background: #427777;
+}
+.clock {
+	background: #fff;
+	color:      #222;
+	border: 1px solid #ccc;
+}
+.day-title {
+	color: #222;
+	text-shadow: none;
+}
+`,
+}
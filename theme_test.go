@@ -0,0 +1,23 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThemesEndingContribution(t *testing.T) {
+	for name, css := range builtinThemes {
+		i := strings.Index(css, ".current-contribution {")
+		if i < 0 {
+			continue
+		}
+		j := strings.Index(css, ".current-contribution.ending-contribution {")
+		if j < i {
+			t.Errorf("theme %q does not style the contributions about to end after the active ones", name)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright ©2016 The ji-web-display Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// loadCertificate loads the TLS certificate and key from the given files.
+// When selfSigned is true and the files do not exist, a self-signed
+// certificate is generated instead, and written to these files, if any.
+func loadCertificate(certFile, keyFile string, selfSigned bool) (tls.Certificate, error) {
+	if !selfSigned {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	if certFile != "" && keyFile != "" {
+		_, errc := os.Stat(certFile)
+		_, errk := os.Stat(keyFile)
+		if errc == nil && errk == nil {
+			return tls.LoadX509KeyPair(certFile, keyFile)
+		}
+	}
+
+	slog.Info("generating self-signed certificate...")
+	certPEM, keyPEM, err := selfSignedCert(certHosts())
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not generate self-signed certificate: %v", err)
+	}
+
+	if certFile != "" && keyFile != "" {
+		err = ioutil.WriteFile(certFile, certPEM, 0644)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		err = ioutil.WriteFile(keyFile, keyPEM, 0600)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		slog.Info("self-signed certificate written", "file", certFile)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// certHosts returns the host names and IP addresses under which the displays
+// may reach this machine.
+func certHosts() []string {
+	hosts := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil {
+		hosts = append(hosts, host)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		if ip, ok := addr.(*net.IPNet); ok {
+			hosts = append(hosts, ip.IP.String())
+		}
+	}
+	return hosts
+}
+
+// selfSignedCert returns a PEM-encoded self-signed certificate, valid for a
+// year for the given hosts, and its PEM-encoded private key.
+func selfSignedCert(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ji-web-display"}},
+		NotBefore:             now.Add(-1 * time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			continue
+		}
+		tmpl.DNSNames = append(tmpl.DNSNames, h)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	return certPEM, keyPEM, nil
+}
+
+// redirectHandler redirects all requests to the HTTPS server listening on
+// the given address.
+func redirectHandler(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		switch {
+		case port != "" && port != "443":
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]"
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}